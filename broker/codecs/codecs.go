@@ -1,6 +1,8 @@
 package codecs
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -35,6 +37,38 @@ func NewCodecReader(r io.Reader, codec pb.CompressionCodec) (Decompressor, error
 	}
 }
 
+// NewAutoCodecReader returns a Decompressor of the Reader, which is decoded
+// per the CompressionCodec identified by its leading magic bytes: GZIP
+// (1f 8b), ZSTANDARD (28 b5 2f fd), or SNAPPY (the "sNaPpY" stream
+// identifier). It's intended for content, such as objects of a bucket,
+// which lacks reliable metadata of its encoding. Content having none of
+// these magic bytes is passed through unchanged. Peeked bytes are buffered,
+// and remain readable by the Decompressor in either case.
+func NewAutoCodecReader(r io.Reader) (Decompressor, error) {
+	var br = bufio.NewReader(r)
+	// Peek returns fewer bytes (and an error) if |r| is shorter than the
+	// longest magic. It may still be matched against shorter ones.
+	var b, _ = br.Peek(len(snappyMagic))
+
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		return NewCodecReader(br, pb.CompressionCodec_GZIP)
+	case bytes.HasPrefix(b, zstdMagic):
+		return NewCodecReader(br, pb.CompressionCodec_ZSTANDARD)
+	case bytes.HasPrefix(b, snappyMagic):
+		return NewCodecReader(br, pb.CompressionCodec_SNAPPY)
+	default:
+		return NewCodecReader(br, pb.CompressionCodec_NONE)
+	}
+}
+
+// Magic bytes which begin content of each CompressionCodec.
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}
+)
+
 // NewCodecWriter returns a Compressor wrapping the Writer encoding with CompressionCodec.
 func NewCodecWriter(w io.Writer, codec pb.CompressionCodec) (Compressor, error) {
 	switch codec {
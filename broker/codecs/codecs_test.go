@@ -0,0 +1,58 @@
+package codecs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	pb "go.gazette.dev/core/broker/protocol"
+)
+
+func TestAutoCodecReaderRoundTrip(t *testing.T) {
+	var content = bytes.Repeat([]byte("hello, world! "), 1000)
+
+	for _, codec := range []pb.CompressionCodec{
+		pb.CompressionCodec_NONE,
+		pb.CompressionCodec_GZIP,
+		pb.CompressionCodec_SNAPPY,
+		pb.CompressionCodec_ZSTANDARD,
+	} {
+		var buf bytes.Buffer
+		var w, err = NewCodecWriter(&buf, codec)
+		if codec == pb.CompressionCodec_ZSTANDARD && err != nil {
+			continue // Built with nozstd.
+		}
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		if codec != pb.CompressionCodec_NONE {
+			require.NotEqual(t, content, buf.Bytes(), codec)
+		}
+
+		r, err := NewAutoCodecReader(&buf)
+		require.NoError(t, err)
+		out, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, content, out, codec)
+	}
+}
+
+func TestAutoCodecReaderPassthrough(t *testing.T) {
+	for _, content := range [][]byte{
+		nil,                     // Empty.
+		{0x1f},                  // Shorter than any magic.
+		{0x28, 0xb5, 0x2f},      // Partial ZSTANDARD magic.
+		[]byte("plain content"), // No magic.
+		{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p'}, // Partial SNAPPY magic.
+	} {
+		var r, err = NewAutoCodecReader(bytes.NewReader(content))
+		require.NoError(t, err)
+		out, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, string(content), string(out))
+	}
+}
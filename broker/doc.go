@@ -24,4 +24,22 @@ var (
 		Name: "gazette_write_head",
 		Help: "Current write head of the journal (i.e., next byte offset to be written).",
 	}, []string{"journal"})
+	localReplicasGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gazette_local_replicas",
+		Help: "Current number of journal replicas assigned to this broker.",
+	})
+	localReplicaStatesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gazette_local_replicas_by_state",
+		Help: "Current number of journal replicas assigned to this broker, by state " +
+			"(recovering: assignments don't yet advertise a consistent Route; primary; or standby).",
+	}, []string{"state"})
+	replicaRecoverySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gazette_replica_recovery_seconds",
+		Help:    "Duration from the creation of a local journal replica until its fragment index completes an initial refresh.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+	})
+	keySpaceRevisionLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gazette_keyspace_revision_lag",
+		Help: "Number of Etcd revisions by which the broker KeySpace trailed the Etcd cluster, as of its last measurement.",
+	})
 )
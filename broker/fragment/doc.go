@@ -66,4 +66,12 @@ var (
 		Name: "gazette_store_persisted_bytes_total",
 		Help: "Cumulative number of bytes persisted to fragment stores.",
 	}, []string{"provider"})
+	storeReadBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gazette_store_read_bytes_total",
+		Help: "Cumulative number of bytes read from opened fragments of fragment stores.",
+	}, []string{"provider"})
+	storeRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gazette_store_request_seconds",
+		Help: "Duration of fragment store operations. The \"open\" operation measures only the opening of a fragment, and not its read.",
+	}, []string{"provider", "operation"})
 )
@@ -2,11 +2,14 @@ package fragment
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -15,12 +18,22 @@ import (
 
 // FileSystemStoreRoot is the filesystem path which roots fragment ContentPaths
 // of a file:// fragment store. It must be set at program startup prior to use.
+// Store paths are joined to the root and cleaned, so that redundant slashes
+// and "." segments are removed and ".." segments are resolved. A path which
+// would then fall outside of the root (eg, "file:///../other/") is an error.
 var FileSystemStoreRoot = "/dev/null/must/configure/file/store/root"
 
 // FileStoreConfig configures a Fragment store of the "file://" scheme.
 // It is initialized from parsed URL parameters of the pb.FragmentStore.
 type FileStoreConfig struct {
 	RewriterConfig
+	// Durable fsyncs each persisted fragment file, and then its directory, so
+	// that the fragment is durable before its persist is reported complete.
+	// Directories created to hold the fragment aren't themselves synced
+	// within their parents. By default, persisted fragments are flushed to
+	// disk at the discretion of the OS. Directories aren't synced on Windows,
+	// which doesn't support it.
+	Durable bool
 }
 
 type fsBackend struct{}
@@ -44,7 +57,10 @@ func (s fsBackend) Exists(_ context.Context, ep *url.URL, fragment pb.Fragment)
 		return false, err
 	}
 
-	var path = filepath.Join(FileSystemStoreRoot, filepath.FromSlash(cfg.rewritePath(ep.Path, fragment.ContentPath())))
+	path, err := fsStorePath(cfg.rewritePath(ep.Path, fragment.ContentPath()))
+	if err != nil {
+		return false, err
+	}
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return false, nil
@@ -61,7 +77,10 @@ func (s fsBackend) Open(_ context.Context, ep *url.URL, fragment pb.Fragment) (i
 		return nil, err
 	}
 
-	var path = filepath.Join(FileSystemStoreRoot, filepath.FromSlash(cfg.rewritePath(ep.Path, fragment.ContentPath())))
+	path, err := fsStorePath(cfg.rewritePath(ep.Path, fragment.ContentPath()))
+	if err != nil {
+		return nil, err
+	}
 	return os.Open(path)
 }
 
@@ -71,7 +90,10 @@ func (s fsBackend) Persist(_ context.Context, ep *url.URL, spool Spool) error {
 		return err
 	}
 
-	var path = filepath.Join(FileSystemStoreRoot, filepath.FromSlash(cfg.rewritePath(ep.Path, spool.ContentPath())))
+	path, err := fsStorePath(cfg.rewritePath(ep.Path, spool.ContentPath()))
+	if err != nil {
+		return err
+	}
 
 	// Create the fragment's directory, if not already present.
 	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
@@ -99,23 +121,47 @@ func (s fsBackend) Persist(_ context.Context, ep *url.URL, spool Spool) error {
 		_, err = io.Copy(f, io.NewSectionReader(spool.compressedFile, 0, spool.compressedLength))
 	}
 
+	if err == nil && cfg.Durable {
+		err = syncFile(f)
+	}
 	if err == nil {
 		err = f.Close()
 	}
 	if err == nil {
 		err = os.Link(f.Name(), path)
 	}
+	if err == nil && cfg.Durable && runtime.GOOS != "windows" {
+		err = syncDir(filepath.Dir(path))
+	}
 	return err
 }
 
+// syncDir fsyncs directory |dir|, making durable the entries linked into it.
+func syncDir(dir string) error {
+	var d, err = os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = syncFile(d); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// syncFile fsyncs a File. It's a variable to allow tests to observe syncs.
+var syncFile = (*os.File).Sync
+
 func (s fsBackend) List(_ context.Context, store pb.FragmentStore, ep *url.URL, journal pb.Journal, callback func(pb.Fragment)) error {
 	var cfg, err = s.fsCfg(ep)
 	if err != nil {
 		return err
 	}
 
-	var dir = filepath.Join(FileSystemStoreRoot,
-		filepath.FromSlash(cfg.rewritePath(ep.Path, journal.String()+"/")))
+	dir, err := fsStorePath(cfg.rewritePath(ep.Path, journal.String()+"/"))
+	if err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		return nil
@@ -161,10 +207,28 @@ func (s fsBackend) Remove(_ context.Context, fragment pb.Fragment) error {
 		return err
 	}
 
-	var path = filepath.Join(FileSystemStoreRoot, filepath.FromSlash(cfg.rewritePath(ep.Path, fragment.ContentPath())))
+	path, err := fsStorePath(cfg.rewritePath(ep.Path, fragment.ContentPath()))
+	if err != nil {
+		return err
+	}
 	return os.Remove(path)
 }
 
+// fsStorePath maps the slash-separated store path |rel| to a cleaned path
+// under FileSystemStoreRoot. It returns an error if the path falls outside of
+// the root, as can happen if |rel| has ".." segments (from the store URL, or
+// its RewriterConfig).
+func fsStorePath(rel string) (string, error) {
+	var root = filepath.Clean(FileSystemStoreRoot)
+	var path = filepath.Join(root, filepath.FromSlash(rel))
+
+	if r, err := filepath.Rel(root, path); err != nil ||
+		r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file store path %q falls outside of FileSystemStoreRoot %q", rel, root)
+	}
+	return path, nil
+}
+
 func (s fsBackend) fsCfg(ep *url.URL) (cfg FileStoreConfig, err error) {
 	err = parseStoreArgs(ep, &cfg)
 	return cfg, err
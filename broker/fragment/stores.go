@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"reflect"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/gorilla/schema"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	pb "go.gazette.dev/core/broker/protocol"
 )
 
@@ -61,22 +63,30 @@ func getBackend(scheme string) backend {
 func SignGetURL(fragment pb.Fragment, d time.Duration) (string, error) {
 	var ep = fragment.BackingStore.URL()
 	var b = getBackend(ep.Scheme)
+	var started = time.Now()
 
 	var signedURL, err = b.SignGet(ep, fragment, d)
-	instrumentStoreOp(b.Provider(), "get_signed_url", err)
+	instrumentStoreOp(b.Provider(), "get_signed_url", started, err)
 	return signedURL, err
 }
 
 // Open a Reader of the Fragment on the store. The returned ReadCloser does not
 // perform any applicable client-side decompression, but does request server
-// decompression in the case of GZIP_OFFLOAD_DECOMPRESSION.
+// decompression in the case of GZIP_OFFLOAD_DECOMPRESSION. If the Fragment is
+// archived and must be restored before it can be read, the returned error's
+// Cause is ErrRestoreRequired.
 func Open(ctx context.Context, fragment pb.Fragment) (io.ReadCloser, error) {
 	var ep = fragment.BackingStore.URL()
 	var b = getBackend(ep.Scheme)
+	var started = time.Now()
 
 	var rc, err = b.Open(ctx, ep, fragment)
-	instrumentStoreOp(b.Provider(), "open", err)
-	return rc, err
+	instrumentStoreOp(b.Provider(), "open", started, err)
+
+	if err != nil {
+		return nil, err
+	}
+	return countingReadCloser{rc, storeReadBytesTotal.WithLabelValues(b.Provider())}, nil
 }
 
 // Persist a Spool to the JournalSpec's store. If the Spool Fragment is already
@@ -98,9 +108,10 @@ func Persist(ctx context.Context, spool Spool, spec *pb.JournalSpec) error {
 
 	var ep = spool.Fragment.BackingStore.URL()
 	var b = getBackend(ep.Scheme)
+	var started = time.Now()
 
 	var exists, err = b.Exists(ctx, ep, spool.Fragment.Fragment)
-	instrumentStoreOp(b.Provider(), "exist", err)
+	instrumentStoreOp(b.Provider(), "exist", started, err)
 	if err != nil {
 		return err
 	} else if exists {
@@ -120,10 +131,11 @@ func Persist(ctx context.Context, spool Spool, spec *pb.JournalSpec) error {
 	var timeoutCtx, cancel = context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
+	started = time.Now()
 	if err = b.Persist(timeoutCtx, ep, spool); err == nil {
 		storePersistedBytesTotal.WithLabelValues(b.Provider()).Add(float64(spool.ContentLength()))
 	}
-	instrumentStoreOp(b.Provider(), "persist", err)
+	instrumentStoreOp(b.Provider(), "persist", started, err)
 	return err
 }
 
@@ -132,23 +144,33 @@ func Persist(ctx context.Context, spool Spool, spec *pb.JournalSpec) error {
 func List(ctx context.Context, store pb.FragmentStore, name pb.Journal, callback func(pb.Fragment)) error {
 	var ep = store.URL()
 	var b = getBackend(ep.Scheme)
+	var started = time.Now()
 
 	var err = b.List(ctx, store, ep, name, callback)
-	instrumentStoreOp(b.Provider(), "list", err)
+	instrumentStoreOp(b.Provider(), "list", started, err)
 	return err
 }
 
 // Remove |fragment| from its BackingStore.
 func Remove(ctx context.Context, fragment pb.Fragment) error {
 	var b = getBackend(fragment.BackingStore.URL().Scheme)
+	var started = time.Now()
+
 	var err = b.Remove(ctx, fragment)
-	instrumentStoreOp(b.Provider(), "remove", err)
+	instrumentStoreOp(b.Provider(), "remove", started, err)
 	return err
 }
 
 func parseStoreArgs(ep *url.URL, args interface{}) error {
 	var decoder = schema.NewDecoder()
 	decoder.IgnoreUnknownKeys(false)
+	// Decode durations from their string form (eg, "30s").
+	decoder.RegisterConverter(time.Duration(0), func(s string) reflect.Value {
+		if d, err := time.ParseDuration(s); err == nil {
+			return reflect.ValueOf(d)
+		}
+		return reflect.Value{} // Invalid, and a conversion error.
+	})
 
 	if q, err := url.ParseQuery(ep.RawQuery); err != nil {
 		return err
@@ -158,7 +180,9 @@ func parseStoreArgs(ep *url.URL, args interface{}) error {
 	return nil
 }
 
-func instrumentStoreOp(provider, op string, err error) {
+func instrumentStoreOp(provider, op string, started time.Time, err error) {
+	storeRequestSeconds.WithLabelValues(provider, op).Observe(time.Since(started).Seconds())
+
 	if err != nil {
 		storeRequestTotal.WithLabelValues(provider, op, errors.Cause(err).Error()).Inc()
 	} else {
@@ -166,6 +190,18 @@ func instrumentStoreOp(provider, op string, err error) {
 	}
 }
 
+// countingReadCloser counts bytes read from an opened store fragment.
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (c countingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = c.ReadCloser.Read(p)
+	c.counter.Add(float64(n))
+	return
+}
+
 func evalPathPostfix(spool Spool, spec *pb.JournalSpec) (string, error) {
 	var tpl, err = template.New("").Parse(spec.Fragment.PathPostfixTemplate)
 	if err != nil {
@@ -214,3 +250,8 @@ func (cfg RewriterConfig) rewritePath(s, j string) string {
 	}
 	return s + strings.Replace(j, cfg.Find, cfg.Replace, 1)
 }
+
+// ErrRestoreRequired is returned by Open if the Fragment is held in an
+// archival storage class of its store (eg, S3 GLACIER), and must be restored
+// before it can be read.
+var ErrRestoreRequired = errors.New("fragment must be restored from archival storage before it can be read")
@@ -37,11 +37,12 @@ func (svc *Service) ListFragments(ctx context.Context, req *pb.FragmentsRequest)
 	}
 
 	res, err = svc.resolver.resolve(resolveArgs{
-		ctx:            ctx,
-		journal:        req.Journal,
-		mayProxy:       !req.DoNotProxy,
-		requirePrimary: false,
-		proxyHeader:    req.Header,
+		ctx:                  ctx,
+		journal:              req.Journal,
+		mayProxy:             !req.DoNotProxy,
+		requirePrimary:       false,
+		proxyHeader:          req.Header,
+		allowPendingDeletion: true,
 	})
 
 	if err != nil {
@@ -38,11 +38,12 @@ func (svc *Service) Read(req *pb.ReadRequest, stream pb.Journal_ReadServer) (err
 	}
 
 	resolved, err = svc.resolver.resolve(resolveArgs{
-		ctx:            stream.Context(),
-		journal:        req.Journal,
-		mayProxy:       !req.DoNotProxy,
-		requirePrimary: false,
-		proxyHeader:    req.Header,
+		ctx:                  stream.Context(),
+		journal:              req.Journal,
+		mayProxy:             !req.DoNotProxy,
+		requirePrimary:       false,
+		proxyHeader:          req.Header,
+		allowPendingDeletion: true,
 	})
 
 	if err != nil {
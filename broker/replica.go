@@ -178,6 +178,18 @@ func shutDownReplica(r *replica, done func()) {
 	done()
 }
 
+// isRecovering returns true if the replica's fragment index hasn't yet completed
+// an initial refresh from the journal's fragment stores. Until it has, reads of
+// the replica may block on offsets which are actually persisted.
+func (r *replica) isRecovering() bool {
+	select {
+	case <-r.index.FirstRefreshCh():
+		return false
+	default:
+		return true
+	}
+}
+
 // updateAssignments values to reflect the Route implied by |assignments|,
 // as an Etcd transaction.
 func updateAssignments(ctx context.Context, assignments keyspace.KeyValues, etcd clientv3.KV) (int64, error) {
@@ -46,6 +46,9 @@ func (svc *Service) Replicate(stream pb.Journal_ReplicateServer) (err error) {
 			mayProxy:       false,
 			requirePrimary: false,
 			proxyHeader:    req.Header,
+			// Continue to replicate a pipeline which was established before
+			// the journal was labeled; its primary refuses new appends.
+			allowPendingDeletion: true,
 		})
 		if err != nil {
 			return err
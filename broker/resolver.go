@@ -3,7 +3,9 @@ package broker
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -12,6 +14,7 @@ import (
 	pb "go.gazette.dev/core/broker/protocol"
 	pbx "go.gazette.dev/core/broker/protocol/ext"
 	"go.gazette.dev/core/keyspace"
+	"go.gazette.dev/core/labels"
 )
 
 // resolver maps journals to responsible broker instances and, potentially, a local replica.
@@ -22,6 +25,23 @@ type resolver struct {
 	replicas map[pb.Journal]*resolverReplica
 	// newReplica builds a new local replica instance.
 	newReplica func(pb.Journal) *replica
+	// routePolicy selects among peers to which a resolution may be proxied.
+	routePolicy RoutePolicy
+	// clock is the source of time of the resolver. See setClock.
+	clock clock
+	// notFound caches journals which don't exist at the current revision.
+	notFound notFoundCache
+	// lastUpdate is the time of the most recent KeySpace update.
+	lastUpdate time.Time
+	// routeGens tracks route generations of resolved journals.
+	routeGens routeGenerations
+	// watchers of local assignment changes.
+	watchers map[*assignmentWatcher]struct{}
+	// Optional hooks invoked with each local replica as it's created, and
+	// after it's stopped. See setReplicaHooks.
+	onReplicaCreated, onReplicaStopped func(pb.Journal, *replica)
+	// etcd client used to load historical KeySpaces, or nil.
+	etcd *clientv3.Client
 	// wg synchronizes over all running local replicas.
 	wg sync.WaitGroup
 }
@@ -32,13 +52,18 @@ type resolverReplica struct {
 	*replica
 	assignments keyspace.KeyValues
 	signalCh    chan struct{}
+	// createdCh is closed upon the return of the resolver's onReplicaCreated hook.
+	createdCh chan struct{}
 }
 
 func newResolver(state *allocator.State, newReplica func(pb.Journal) *replica) *resolver {
 	var r = &resolver{
-		state:      state,
-		replicas:   make(map[pb.Journal]*resolverReplica),
-		newReplica: newReplica,
+		state:       state,
+		replicas:    make(map[pb.Journal]*resolverReplica),
+		newReplica:  newReplica,
+		routePolicy: anyMemberRoutePolicy{},
+		clock:       realClock{},
+		watchers:    make(map[*assignmentWatcher]struct{}),
 	}
 	state.KS.Mu.Lock()
 	state.KS.Observers = append(state.KS.Observers, r.updateResolutions)
@@ -46,6 +71,77 @@ func newResolver(state *allocator.State, newReplica func(pb.Journal) *replica) *
 	return r
 }
 
+// clock is a source of the current time, and of timers. The resolver's
+// time-dependent behaviors (wait deadlines, staleness, cache TTLs) are
+// driven by its clock, which tests may substitute to control the passage
+// of time deterministically.
+type clock interface {
+	Now() time.Time
+	// AfterFunc invokes |f| in its own goroutine after |d| has elapsed, and
+	// returns a func which stops the timer. Stop returns false if |f| was
+	// already invoked or stopped.
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// realClock is a clock of the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) func() bool { return time.AfterFunc(d, f).Stop }
+
+// RoutePolicy selects a specific peer to which a resolution is proxied, in
+// cases where resolve would otherwise permit the request to be dispatched to
+// any member of the journal's Route: the request may be proxied, the primary
+// broker isn't required, and this broker isn't itself a Route member.
+type RoutePolicy interface {
+	// SelectProxy returns the ID of the Route member to which a request of
+	// |journal|, resolved by broker |local|, should be proxied, and true.
+	// Or, it returns false if the request may be dispatched to any Route
+	// member. A returned ID which is not a member of the Route is ignored.
+	//
+	// SelectProxy is called while the KeySpace read lock is held, and must
+	// not block. Nor may it retain |route|, which is owned by the resolution.
+	SelectProxy(local pb.ProcessSpec_ID, journal pb.Journal, route pb.Route) (pb.ProcessSpec_ID, bool)
+}
+
+// anyMemberRoutePolicy is the default RoutePolicy, which leaves the choice of
+// Route member to the dispatcher of the proxied request.
+type anyMemberRoutePolicy struct{}
+
+func (anyMemberRoutePolicy) SelectProxy(pb.ProcessSpec_ID, pb.Journal, pb.Route) (pb.ProcessSpec_ID, bool) {
+	return pb.ProcessSpec_ID{}, false
+}
+
+// RTTSource returns the measured round-trip time to a peer broker, and true,
+// or false if no measurement of the peer is available. It must not block.
+type RTTSource func(pb.ProcessSpec_ID) (time.Duration, bool)
+
+// NewNearestPeerRoutePolicy returns a RoutePolicy which proxies to the Route
+// member having the lowest round-trip time, as reported by |rtt|. Members
+// lacking a measurement are never selected. If no member has a measurement,
+// or the Route has a single member, the request may be dispatched to any
+// Route member.
+func NewNearestPeerRoutePolicy(rtt RTTSource) RoutePolicy { return nearestPeerRoutePolicy{rtt: rtt} }
+
+type nearestPeerRoutePolicy struct{ rtt RTTSource }
+
+func (p nearestPeerRoutePolicy) SelectProxy(_ pb.ProcessSpec_ID, _ pb.Journal, route pb.Route) (pb.ProcessSpec_ID, bool) {
+	if len(route.Members) < 2 {
+		return pb.ProcessSpec_ID{}, false
+	}
+	var nearest pb.ProcessSpec_ID
+	var nearestRTT time.Duration
+	var found bool
+
+	for _, id := range route.Members {
+		if rtt, ok := p.rtt(id); ok && (!found || rtt < nearestRTT) {
+			nearest, nearestRTT, found = id, rtt, true
+		}
+	}
+	return nearest, found
+}
+
 type resolveArgs struct {
 	ctx context.Context
 	// Journal to be dispatched.
@@ -56,8 +152,69 @@ type resolveArgs struct {
 	requirePrimary bool
 	// Minimum Etcd Revision to have read through, before generating a resolution.
 	minEtcdRevision int64
+	// Optional deadline which bounds only the wait for |minEtcdRevision|,
+	// and not the overall |ctx|. If it elapses before |minEtcdRevision| is
+	// read through, a stale resolution of the current revision is returned
+	// instead of an error. A stale resolution's Header.Etcd.Revision is less
+	// than |minEtcdRevision|, which callers may compare to detect it.
+	//
+	// Requests which require the primary broker, or which were proxied by a
+	// peer having a |proxyHeader|, must be served at an authoritative
+	// revision and never resolve stale: for these the elapsed deadline
+	// is returned as an error.
+	waitDeadline time.Time
+	// Optional duration after which the KeySpace is presumed to be partitioned
+	// from Etcd, if it hasn't been updated within that time. If |minEtcdRevision|
+	// hasn't been read through and the KeySpace is presumed partitioned, a stale
+	// resolution of the current revision is returned rather than blocking.
+	// The KeySpace is updated by Etcd progress notifications of its watch even
+	// if no keys change, and |tolerateStale| should exceed their interval.
+	// As with |waitDeadline|, primary and proxied requests are never stale.
+	tolerateStale time.Duration
+	// If true, and |minEtcdRevision| hasn't been read through, fail
+	// immediately with errRevisionNotReached rather than waiting for it.
+	// The caller may retry on its own schedule. A stale resolution permitted
+	// by |tolerateStale| is still returned instead of the error.
+	nonBlocking bool
 	// Optional Header attached to the request from a proxying peer.
 	proxyHeader *pb.Header
+	// Optional route generation of a Route which the caller has cached.
+	// If the journal's route generation is unchanged, the resolution is
+	// marked |routeUnchanged| and its Route omits Endpoints, which the caller
+	// is expected to retain from its cached Route. Otherwise the full Route is
+	// resolved. Resolutions omitting Endpoints can't be used to dispatch or
+	// proxy requests, and journal RPC handlers never set this field.
+	cachedRouteGeneration int64
+	// Optional exact Etcd revision at which to resolve. Unlike |minEtcdRevision|,
+	// which is a floor, the resolution reflects the journal's JournalSpec and
+	// assignments as of precisely this revision. If the KeySpace has moved past
+	// it, a KeySpace of the revision is loaded from Etcd's history, which fails
+	// if the revision has been compacted. Historical resolutions never attach
+	// a local replica, and are intended for replay and inspection tooling.
+	atEtcdRevision int64
+	// Optional resolveSnapshot at which to resolve. It sets |atEtcdRevision|
+	// to the snapshot's revision, and resolutions of multiple journals under
+	// a snapshot share a single KeySpace loaded at that revision.
+	snapshot *resolveSnapshot
+	// If true, attach the BrokerSpec of the resolved ProcessId.
+	withBrokerSpec bool
+	// If true, attach resolveCandidates of each Route member.
+	withCandidates bool
+	// If true, journals labeled PendingDeletion resolve as usual. Otherwise
+	// their resolutions have status NOT_ALLOWED, which stops writers of the
+	// journal. Readers set this to continue serving the journal's content.
+	allowPendingDeletion bool
+	// If true, a non-primary resolution may be served by a recovering local
+	// replica, accepting that reads of it may block or lag. Otherwise, and if
+	// we may proxy to another Route member, a recovering local replica is
+	// skipped. See resolution.recovering.
+	allowRecovering bool
+	// If true, resolve only to the local replica of the journal, whether or
+	// not it's primary, and never proxy. If the journal has no local replica
+	// an error wrapping errNoLocalReplica is returned, rather than a
+	// NOT_JOURNAL_BROKER resolution. It may not be combined with |mayProxy|,
+	// |requirePrimary|, or a historical |atEtcdRevision| or |snapshot|.
+	localOnly bool
 }
 
 type resolution struct {
@@ -73,12 +230,78 @@ type resolution struct {
 	journalSpec *pb.JournalSpec
 	// Assignments of the Journal at the current Etcd Revision.
 	assignments keyspace.KeyValues
+	// RouteGeneration of the Journal, which increases with each change of
+	// its assignments. See routeGenerations.
+	routeGeneration int64
+	// PrimaryTerm is the Etcd CreateRevision of the journal's primary
+	// assignment, or zero if there's no primary. The allocator creates a new
+	// assignment key whenever it assigns the primary role, so a changed term
+	// means a changed primary tenure even if the same broker holds the role.
+	// ResponseHeader has no field to carry it, but it's logged with the
+	// resolution to help diagnose primary flapping.
+	primaryTerm int64
+	// RouteUnchanged is true if |routeGeneration| matches the requested
+	// resolveArgs.cachedRouteGeneration, in which case Route.Endpoints are
+	// omitted. Route generations track assignments and not member endpoints,
+	// so callers which fail to reach a cached endpoint should resolve anew
+	// without a cached generation.
+	routeUnchanged bool
+	// SuggestedCacheTTL is a duration for which a client may cache the
+	// resolved Route, which is longer for journals whose Route has been
+	// stable for longer, as observed by this resolver. It's a hint only:
+	// cached Routes are still corrected by the Headers of responses.
+	// It's zero for resolutions from a KeySpace loaded at a past revision.
+	suggestedCacheTTL time.Duration
+	// Recovering is true if the resolution is to a local replica which hasn't
+	// completed an initial refresh of its fragment index.
+	recovering bool
+	// BrokerSpec of the resolved ProcessId, at the resolution's Etcd revision.
+	// It's set only if requested by resolveArgs.withBrokerSpec, the status is
+	// OK, and the resolution has a ProcessId (rather than proxying to any
+	// Route member).
+	brokerSpec *pb.BrokerSpec
+	// Candidates are the members of the Route, in Route order, with the
+	// capacities of their BrokerSpecs. They're set only if requested by
+	// resolveArgs.withCandidates.
+	candidates []resolveCandidate
+	// FromProxyHeader is true if the request was proxied by a peer, whose
+	// resolveArgs.proxyHeader was validated. The resolution is nonetheless
+	// computed locally, at a revision no less than the proxy's, and differs
+	// from the proxy's Header only if the Route has since changed. It allows
+	// routing decisions of multi-hop proxying to be told apart.
+	fromProxyHeader bool
+	// ReadPeers hint at Route members which could serve a read of the journal
+	// in lieu of its primary. They're set only if the status is
+	// NO_JOURNAL_PRIMARY_BROKER, and are the non-primary members of the Route
+	// having Endpoints, in Route order. This broker is included only if its
+	// local replica isn't recovering. Such reads may be stale, and a client
+	// must opt into them. ResponseHeader has no field to carry the hint,
+	// though it's equivalently derived from the Route of a response.
+	readPeers []pb.ProcessSpec_ID
 	// Local replica of the assigned journal, if one exists.
 	replica *replica
 	// If |replica| is non-nil, |invalidateCh| is also, and is closed when
 	// this resolution has been invalidated due to a subsequent assignment
 	// update of the journal.
 	invalidateCh <-chan struct{}
+	// Stale is true if the resolution is of an Etcd revision which is less
+	// than the requested minimum revision, because resolveArgs.waitDeadline
+	// elapsed before it could be read through, or because the KeySpace wasn't
+	// updated within resolveArgs.tolerateStale. Header.Etcd.Revision of a
+	// stale resolution is less than the requested minimum.
+	stale bool
+}
+
+// HasPrimary returns whether the resolved Route has a primary member.
+// Route.Primary is an index into Route.Members, or -1 if there's no primary.
+func (r resolution) HasPrimary() bool {
+	return r.Route.Primary >= 0 && int(r.Route.Primary) < len(r.Route.Members)
+}
+
+// IsPrimaryLocal returns whether the resolved Route has a primary member,
+// and it's this broker.
+func (r resolution) IsPrimaryLocal() bool {
+	return r.HasPrimary() && r.Route.Members[r.Route.Primary] == r.localID
 }
 
 func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
@@ -88,9 +311,226 @@ func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
 	// Discard metadata path segment, which doesn't alter resolution outcomes.
 	args.journal = args.journal.StripMeta()
 
+	if args.localOnly && (args.mayProxy || args.requirePrimary ||
+		args.atEtcdRevision != 0 || args.snapshot != nil) {
+		return res, fmt.Errorf("localOnly resolution of %s may not proxy, require the primary, or be historical",
+			args.journal)
+	}
+	if args.snapshot != nil {
+		args.atEtcdRevision = args.snapshot.revision
+	}
+	if args.atEtcdRevision != 0 {
+		return r.resolveAt(args)
+	}
+
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	if err = r.readThrough(&args, res); err == nil {
+		err = r.resolveLocked(args, res)
+	}
+	return
+}
+
+// resolveUntilOK resolves |args| under |ctx| until its status is OK, or is a
+// status for which |retryable| returns false, such as JOURNAL_NOT_FOUND. A
+// retryable status, like NO_JOURNAL_PRIMARY_BROKER during an election, is
+// resolved again once the KeySpace reads through a later revision. Retries
+// always await that revision, regardless of the |waitDeadline| or
+// |tolerateStale| of |args|. resolveUntilOK returns an error if a resolution
+// fails, including if |ctx| is done.
+func (r *resolver) resolveUntilOK(ctx context.Context, args resolveArgs, retryable func(pb.Status) bool) (*resolution, error) {
+	args.ctx = ctx
+
+	for {
+		var res, err = r.resolve(args)
+		if err != nil || res.status == pb.Status_OK || !retryable(res.status) {
+			return res, err
+		}
+		addTrace(ctx, " ... resolved %s @ rev %d; awaiting next revision",
+			res.status, res.Etcd.Revision)
+
+		args.minEtcdRevision = res.Etcd.Revision + 1
+		args.waitDeadline, args.tolerateStale = time.Time{}, 0
+	}
+}
+
+// resolveAt resolves |args| at exactly |args.atEtcdRevision|. If the KeySpace
+// reads through precisely that revision, resolution proceeds as usual.
+// Otherwise, the journal is resolved from a KeySpace loaded at the revision.
+func (r *resolver) resolveAt(args resolveArgs) (*resolution, error) {
+	var ks = r.state.KS
+	var res = new(resolution)
+
+	// Read through |atEtcdRevision|. A pinned resolution may not be stale.
+	if args.minEtcdRevision < args.atEtcdRevision {
+		args.minEtcdRevision = args.atEtcdRevision
+	}
+	args.waitDeadline, args.tolerateStale = time.Time{}, 0
+
+	ks.Mu.RLock()
+	var err = r.readThrough(&args, res)
+
+	if err == nil && ks.Header.Revision == args.atEtcdRevision {
+		err = r.resolveLocked(args, res)
+		ks.Mu.RUnlock()
+		return res, err
+	}
+	ks.Mu.RUnlock()
+
+	if err != nil {
+		return res, err
+	} else if args.atEtcdRevision < args.minEtcdRevision {
+		return res, fmt.Errorf("atEtcdRevision %d is less than the minimum Etcd revision %d",
+			args.atEtcdRevision, args.minEtcdRevision)
+	} else if r.etcd == nil {
+		return res, fmt.Errorf("resolver cannot load historical revision %d without an Etcd client",
+			args.atEtcdRevision)
+	}
+
+	// The KeySpace has moved past |atEtcdRevision|. Load a KeySpace of the
+	// revision, without holding our own KeySpace lock.
+	var hist *keyspace.KeySpace
+	if args.snapshot != nil {
+		hist, err = args.snapshot.keySpace(args.ctx, r.etcd, ks.Root)
+	} else {
+		hist = NewKeySpace(ks.Root)
+		err = hist.Load(args.ctx, r.etcd, args.atEtcdRevision)
+	}
+	if err != nil {
+		return res, errors.WithMessagef(err, "loading KeySpace at revision %d", args.atEtcdRevision)
+	}
+
+	if item, ok := allocator.LookupItem(hist, args.journal.String()); ok {
+		res.journalSpec = item.ItemValue.(*pb.JournalSpec)
+	}
+	res.assignments = hist.KeyValues.Prefixed(
+		allocator.ItemAssignmentsPrefix(hist, args.journal.String()))
+	res.primaryTerm = primaryTerm(res.assignments)
+
+	pbx.Init(&res.Route, res.assignments)
+	pbx.AttachEndpoints(&res.Route, hist)
+	res.Etcd = pbx.FromEtcdResponseHeader(hist.Header)
+
+	if args.requirePrimary && res.HasPrimary() {
+		res.ProcessId = res.Route.Members[res.Route.Primary]
+	} else if !args.requirePrimary {
+		for i := range res.Route.Members {
+			if res.Route.Members[i] == res.localID {
+				res.ProcessId = res.localID
+			}
+		}
+	}
+	selectStatus(args, res)
+	attachMembers(hist, args, res)
+
+	addTrace(args.ctx, "resolve(%s) => %s, header: %s (at revision %d)",
+		args.journal, res.status, &res.Header, args.atEtcdRevision)
+	logResolution(args, res)
+
+	return res, nil
+}
+
+// resolveSnapshot pins the resolutions of a transaction which spans multiple
+// journals to a single Etcd revision, so that they can't straddle a Route
+// change even as the KeySpace advances. Snapshots are obtained from
+// resolver.snapshot and passed as resolveArgs.snapshot. Once the KeySpace has
+// moved past the snapshot revision, a KeySpace of the revision is loaded from
+// Etcd and retained by the snapshot for its further resolutions. Resolutions
+// fail if the revision is compacted before that load.
+type resolveSnapshot struct {
+	revision int64
+
+	mu   sync.Mutex
+	hist *keyspace.KeySpace // Loaded on first use.
+}
+
+// snapshot returns a resolveSnapshot of the current KeySpace revision. It
+// returns errNotReady if the KeySpace hasn't yet loaded.
+func (r *resolver) snapshot() (*resolveSnapshot, error) {
+	var ks = r.state.KS
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	if ks.Header.Revision == 0 {
+		return nil, errNotReady
+	}
+	return &resolveSnapshot{revision: ks.Header.Revision}, nil
+}
+
+// keySpace returns the KeySpace of the snapshot revision, loading it under
+// |ctx| if it hasn't been already.
+func (s *resolveSnapshot) keySpace(ctx context.Context, etcd *clientv3.Client, root string) (*keyspace.KeySpace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hist != nil {
+		return s.hist, nil
+	}
+	var hist = NewKeySpace(root)
+	if err := hist.Load(ctx, etcd, s.revision); err != nil {
+		return nil, err
+	}
+	s.hist = hist
+	return hist, nil
+}
+
+// resolveMany resolves each of |journals| using |args|, which should not
+// itself specify a journal. Resolutions are made under a single acquisition of
+// the KeySpace read lock, and all are of the same KeySpace revision. Failure to
+// resolve an individual journal is reflected in its resolution status, but a
+// returned error (eg, a failed |proxyHeader| check or errResolverStopped)
+// fails the entire batch.
+func (r *resolver) resolveMany(ctx context.Context, journals []pb.Journal, args resolveArgs) ([]resolution, error) {
+	var ks = r.state.KS
+	args.ctx = ctx
+
 	ks.Mu.RLock()
 	defer ks.Mu.RUnlock()
 
+	// Read through the requested revision just once. Thereafter we don't
+	// release the read lock, and the KeySpace cannot change.
+	var base resolution
+	if err := r.readThrough(&args, &base); err != nil {
+		return nil, err
+	}
+	var out = make([]resolution, len(journals))
+
+	for i, journal := range journals {
+		out[i] = base
+		args.journal = journal.StripMeta()
+
+		if err := r.resolveLocked(args, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// readThrough populates the local ID of |res|, verifies the |proxyHeader| of
+// |args|, and then waits for the KeySpace to read through the requested Etcd
+// revision, which is set as the Etcd header of |res|. If the KeySpace hasn't
+// yet completed its initial load, readThrough first awaits it, and returns
+// errNotReady if |args.ctx| is done (or |args.nonBlocking|) before it does.
+// The KeySpace read lock must be held, and is released while waiting.
+func (r *resolver) readThrough(args *resolveArgs, res *resolution) (err error) {
+	var ks = r.state.KS
+
+	if ks.Header.Revision == 0 {
+		// The initial KeySpace load hasn't completed, and we'd otherwise
+		// resolve a misleading JOURNAL_NOT_FOUND which a client might act on.
+		// Await the load, or fail with errNotReady.
+		addTrace(args.ctx, " ... awaiting initial KeySpace load")
+
+		if args.nonBlocking {
+			err = errNotReady
+			return
+		} else if err = ks.WaitForRevision(args.ctx, 1); err != nil {
+			err = errNotReady
+			return
+		}
+	}
+
 	if r.state.LocalMemberInd != -1 {
 		res.localID = r.state.Members[r.state.LocalMemberInd].
 			Decoded.(allocator.Member).MemberValue.(*pb.BrokerSpec).Id
@@ -119,34 +559,114 @@ func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
 		if args.proxyHeader.Etcd.Revision > args.minEtcdRevision {
 			args.minEtcdRevision = args.proxyHeader.Etcd.Revision
 		}
+		res.fromProxyHeader = true
 	}
 
-	if args.minEtcdRevision > ks.Header.Revision {
+	if args.minEtcdRevision > ks.Header.Revision &&
+		args.tolerateStale != 0 && !args.requirePrimary && args.proxyHeader == nil &&
+		r.clock.Now().Sub(r.lastUpdate) > args.tolerateStale {
+
+		// We've not heard from Etcd within |tolerateStale|. Rather than block
+		// on a revision which may not arrive, resolve at the current revision.
+		addTrace(args.ctx, " ... at revision %d, want at least %d, but last update was %s ago (stale)",
+			ks.Header.Revision, args.minEtcdRevision, r.clock.Now().Sub(r.lastUpdate))
+		res.stale = true
+
+	} else if args.minEtcdRevision > ks.Header.Revision && args.nonBlocking {
+		addTrace(args.ctx, " ... at revision %d, but want at least %d (non-blocking)",
+			ks.Header.Revision, args.minEtcdRevision)
+		err = errRevisionNotReached
+		return
+
+	} else if args.minEtcdRevision > ks.Header.Revision {
 		addTrace(args.ctx, " ... at revision %d, but want at least %d",
 			ks.Header.Revision, args.minEtcdRevision)
 
-		if err = ks.WaitForRevision(args.ctx, args.minEtcdRevision); err != nil {
+		// Bound the wait by |waitDeadline| as measured by our clock.
+		var waitCtx = args.ctx
+		if !args.waitDeadline.IsZero() {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithCancel(args.ctx)
+			defer cancel()
+
+			var stop = r.clock.AfterFunc(args.waitDeadline.Sub(r.clock.Now()), cancel)
+			defer stop()
+		}
+
+		err = ks.WaitForRevision(waitCtx, args.minEtcdRevision)
+		if err != nil && waitCtx.Err() != nil && args.ctx.Err() == nil {
+			err = context.DeadlineExceeded // |waitDeadline| elapsed.
+		}
+
+		if err == nil {
+			// Pass.
+		} else if err == context.DeadlineExceeded && args.ctx.Err() == nil &&
+			!args.requirePrimary && args.proxyHeader == nil {
+			// |waitDeadline| elapsed, but the overall context has not. Resolve
+			// at the current revision, which may be stale.
+			err = nil
+		} else {
 			return
 		}
-		addTrace(args.ctx, "WaitForRevision(%d) => %d",
-			args.minEtcdRevision, ks.Header.Revision)
+		// WaitForRevision may return a context error even having read through
+		// the revision, so determine staleness from the revision itself.
+		res.stale = ks.Header.Revision < args.minEtcdRevision
+
+		addTrace(args.ctx, "WaitForRevision(%d) => %d (stale: %t)",
+			args.minEtcdRevision, ks.Header.Revision, res.stale)
 	}
 	res.Etcd = pbx.FromEtcdResponseHeader(ks.Header)
+	return
+}
 
-	// Extract JournalSpec.
-	if item, ok := allocator.LookupItem(ks, args.journal.String()); ok {
-		res.journalSpec = item.ItemValue.(*pb.JournalSpec)
+// resolveLocked completes |res| with the resolution of |args.journal|, as of
+// the current KeySpace revision. The KeySpace read lock must be held, and
+// |res| must have been prepared by readThrough.
+func (r *resolver) resolveLocked(args resolveArgs, res *resolution) (err error) {
+	var ks = r.state.KS
+
+	var now = r.clock.Now()
+
+	if r.notFound.contains(args.journal, ks.Header.Revision, now) {
+		// The journal is known not to exist, and has no assignments.
+		addTrace(args.ctx, "resolve(%s) => not-found (cached)", args.journal)
+	} else {
+		// Extract JournalSpec.
+		if item, ok := allocator.LookupItem(ks, args.journal.String()); ok {
+			res.journalSpec = item.ItemValue.(*pb.JournalSpec)
+		}
+		// Extract Assignments.
+		res.assignments = ks.KeyValues.Prefixed(
+			allocator.ItemAssignmentsPrefix(ks, args.journal.String())).Copy()
+
+		if res.journalSpec == nil && len(res.assignments) == 0 {
+			r.notFound.add(args.journal, ks.Header.Revision, now)
+		}
+	}
+
+	var observedAt time.Time
+	res.routeGeneration, observedAt = r.routeGens.observe(args.journal,
+		res.journalSpec != nil, res.assignments, ks.Header.Revision, now)
+	res.suggestedCacheTTL = suggestedRouteCacheTTL(now.Sub(observedAt))
+	res.primaryTerm = primaryTerm(res.assignments)
+	res.routeUnchanged = args.cachedRouteGeneration != 0 &&
+		args.cachedRouteGeneration == res.routeGeneration
+
+	if r.resolveSingleLocal(args, res) {
+		attachMembers(ks, args, res)
+		logResolution(args, res)
+		return
 	}
-	// Extract Assignments and build Route.
-	res.assignments = ks.KeyValues.Prefixed(
-		allocator.ItemAssignmentsPrefix(ks, args.journal.String())).Copy()
 
+	// Build Route.
 	pbx.Init(&res.Route, res.assignments)
-	pbx.AttachEndpoints(&res.Route, ks)
+	if !res.routeUnchanged {
+		pbx.AttachEndpoints(&res.Route, ks)
+	}
 
 	// Select a definite ProcessID if we require the primary and there is one,
 	// or if we're a member of the Route (and authoritative).
-	if args.requirePrimary && res.Route.Primary != -1 {
+	if args.requirePrimary && res.HasPrimary() {
 		res.ProcessId = res.Route.Members[res.Route.Primary]
 	} else if !args.requirePrimary {
 		for i := range res.Route.Members {
@@ -156,6 +676,53 @@ func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
 		}
 	}
 
+	// Skip a recovering local replica if we may proxy to another member.
+	// A request already proxied to us is served locally, so that peers having
+	// recovering replicas don't proxy it back and forth.
+	var skipLocal = res.ProcessId == res.localID && args.mayProxy && !args.requirePrimary &&
+		!args.allowRecovering && args.proxyHeader == nil && len(res.Route.Members) > 1 &&
+		r.replicas[args.journal] != nil && r.replicas[args.journal].isRecovering()
+
+	if skipLocal {
+		res.ProcessId = pb.ProcessSpec_ID{}
+		addTrace(args.ctx, "resolve(%s) => skipping recovering local replica", args.journal)
+	}
+
+	// If we may proxy to any of multiple Route members, allow our RoutePolicy
+	// to pin a specific one.
+	if args.mayProxy && !args.requirePrimary && res.ProcessId == (pb.ProcessSpec_ID{}) &&
+		res.journalSpec != nil && len(res.Route.Members) != 0 {
+
+		if id, ok := r.routePolicy.SelectProxy(res.localID, args.journal, res.Route); ok {
+			for i := range res.Route.Members {
+				if res.Route.Members[i] != id || (skipLocal && id == res.localID) {
+					continue
+				} else if res.routeUnchanged || hasEndpoint(res.Route, id) {
+					res.ProcessId = id
+				} else {
+					// We can't proxy to the member. Leave the choice of
+					// member to the dispatcher.
+					addTrace(args.ctx, "resolve(%s) => selected proxy %s has no Endpoint",
+						args.journal, id)
+				}
+				break
+			}
+		}
+	}
+	// If we're skipping our local replica, and the RoutePolicy didn't select
+	// a peer, select one ourselves rather than let the dispatcher select us.
+	if skipLocal && res.ProcessId == (pb.ProcessSpec_ID{}) {
+		for _, id := range res.Route.Members {
+			if id != res.localID && (res.routeUnchanged || hasEndpoint(res.Route, id)) {
+				res.ProcessId = id
+				break
+			}
+		}
+		if res.ProcessId == (pb.ProcessSpec_ID{}) {
+			res.ProcessId = res.localID // No peer may serve it.
+		}
+	}
+
 	// If the journal is assigned locally, attach our replica to the resolution.
 	if r.replicas == nil && res.ProcessId == res.localID {
 		// The journal still resolves to this broker, but we've stopped local
@@ -170,12 +737,43 @@ func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
 	} else if replica := r.replicas[args.journal]; replica != nil {
 		res.replica = replica.replica
 		res.invalidateCh = replica.signalCh
+		res.recovering = res.ProcessId == res.localID && replica.isRecovering()
+	}
+
+	if args.localOnly && res.replica == nil {
+		addTrace(args.ctx, "resolve(%s) => no local replica", args.journal)
+		err = fmt.Errorf("%w: %s @ revision %d", errNoLocalReplica, args.journal, ks.Header.Revision)
+		return
+	}
+
+	selectStatus(args, res)
+	attachMembers(ks, args, res)
+
+	if res.status == pb.Status_NO_JOURNAL_PRIMARY_BROKER {
+		res.readPeers = readPeers(res)
 	}
 
-	// Select a response Status code.
+	addTrace(args.ctx, "resolve(%s) => %s, local: %t, header: %s",
+		args.journal, res.status, res.replica != nil, &res.Header)
+	logResolution(args, res)
+
+	return
+}
+
+// selectStatus selects the Status of a resolution |res| having a populated
+// journalSpec, Route, and ProcessId. If the Status isn't OK, the effective
+// ProcessId becomes our own.
+func selectStatus(args resolveArgs, res *resolution) {
 	if res.journalSpec == nil {
 		res.status = pb.Status_JOURNAL_NOT_FOUND
-	} else if args.requirePrimary && res.Route.Primary == -1 {
+	} else if !args.allowPendingDeletion && hasLabel(res.journalSpec, labels.PendingDeletion) {
+		res.status = pb.Status_NOT_ALLOWED
+	} else if args.requirePrimary && hasLabel(res.journalSpec, labels.Maintenance) {
+		// Writes are quiesced. Report that no primary is available, which
+		// writers retry, until the label is removed.
+		addTrace(args.ctx, "resolve(%s) => journal is under maintenance", args.journal)
+		res.status = pb.Status_NO_JOURNAL_PRIMARY_BROKER
+	} else if args.requirePrimary && !res.HasPrimary() {
 		res.status = pb.Status_NO_JOURNAL_PRIMARY_BROKER
 	} else if len(res.Route.Members) == 0 {
 		res.status = pb.Status_INSUFFICIENT_JOURNAL_BROKERS
@@ -185,6 +783,13 @@ func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
 		} else {
 			res.status = pb.Status_NOT_JOURNAL_BROKER
 		}
+	} else if args.requirePrimary && res.ProcessId != res.localID &&
+		!res.routeUnchanged && !hasEndpoint(res.Route, res.ProcessId) {
+		// The primary's assignment outlives its BrokerSpec (eg, its Etcd lease
+		// expired), and we can't proxy to it. It remains primary only until
+		// the allocator re-assigns the journal.
+		addTrace(args.ctx, "resolve(%s) => primary %s has no Endpoint", args.journal, res.ProcessId)
+		res.status = pb.Status_NO_JOURNAL_PRIMARY_BROKER
 	} else {
 		res.status = pb.Status_OK
 	}
@@ -194,20 +799,173 @@ func (r *resolver) resolve(args resolveArgs) (res *resolution, err error) {
 	if res.status != pb.Status_OK {
 		res.ProcessId = res.localID
 	}
+}
 
-	addTrace(args.ctx, "resolve(%s) => %s, local: %t, header: %s",
-		args.journal, res.status, res.replica != nil, &res.Header)
+// readPeers returns the ready, non-primary members of the Route of |res|,
+// which could serve a read of the journal. See resolution.readPeers.
+func readPeers(res *resolution) []pb.ProcessSpec_ID {
+	var out []pb.ProcessSpec_ID
+	for i, id := range res.Route.Members {
+		if int32(i) == res.Route.Primary {
+			continue
+		} else if !res.routeUnchanged && !hasEndpoint(res.Route, id) {
+			continue // We can't dispatch to it.
+		} else if id == res.localID && (res.replica == nil || res.replica.isRecovering()) {
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
 
-	return
+// hasLabel returns whether the JournalSpec has a label of the given |name|.
+func hasLabel(spec *pb.JournalSpec, name string) bool {
+	return spec.LabelSet.ValuesOf(name) != nil
+}
+
+// hasEndpoint returns whether Route member |id| has an Endpoint. A member
+// lacks one if its BrokerSpec is missing from the KeySpace, and requests
+// can't be proxied to it.
+func hasEndpoint(rt pb.Route, id pb.ProcessSpec_ID) bool {
+	for i := range rt.Members {
+		if rt.Members[i] == id {
+			return i < len(rt.Endpoints) && rt.Endpoints[i] != ""
+		}
+	}
+	return false
+}
+
+// resolveCandidate is a member of a resolved Route, with the capacity of its
+// BrokerSpec. The allocator weighs assignments, and therefore primary roles,
+// by member capacity, and tooling may compare capacities of candidates with
+// the current primary to detect sub-optimal primary placement.
+type resolveCandidate struct {
+	id pb.ProcessSpec_ID
+	// JournalLimit of the member's BrokerSpec, or zero if it's not known.
+	journalLimit uint32
+	// Whether the member is the Route primary.
+	primary bool
+}
+
+// attachMembers attaches the BrokerSpec of |res|'s ProcessId and the
+// resolveCandidates of its Route from |ks|, as requested by |args|. The
+// BrokerSpec is attached only if the resolution is OK. If |ks| is shared,
+// its read lock must be held.
+func attachMembers(ks *keyspace.KeySpace, args resolveArgs, res *resolution) {
+	if args.withBrokerSpec && res.status == pb.Status_OK && res.ProcessId != (pb.ProcessSpec_ID{}) {
+		if member, ok := allocator.LookupMember(ks, res.ProcessId.Zone, res.ProcessId.Suffix); ok {
+			res.brokerSpec = member.MemberValue.(*pb.BrokerSpec)
+		}
+	}
+	if args.withCandidates {
+		res.candidates = make([]resolveCandidate, len(res.Route.Members))
+
+		for i, id := range res.Route.Members {
+			res.candidates[i] = resolveCandidate{id: id, primary: int32(i) == res.Route.Primary}
+
+			if member, ok := allocator.LookupMember(ks, id.Zone, id.Suffix); ok {
+				res.candidates[i].journalLimit = member.MemberValue.(*pb.BrokerSpec).JournalLimit
+			}
+		}
+	}
+}
+
+// WithResolveLogger returns a Context which causes each journal resolution
+// made with it to be logged to |entry| at debug level. Such Contexts may be
+// passed to Service.Route, or attached to journal RPCs by a gRPC server
+// interceptor. It's a no-op unless |entry| enables debug logging.
+func WithResolveLogger(ctx context.Context, entry *log.Entry) context.Context {
+	return context.WithValue(ctx, resolveLoggerCtxKey{}, entry)
+}
+
+type resolveLoggerCtxKey struct{}
+
+// logResolution logs |res| to the debug logger of the |args| context, if any.
+func logResolution(args resolveArgs, res *resolution) {
+	var entry, ok = args.ctx.Value(resolveLoggerCtxKey{}).(*log.Entry)
+	if !ok || !entry.Logger.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	entry.WithFields(log.Fields{
+		"journal":   args.journal,
+		"processId": res.ProcessId,
+		"status":    res.status,
+		"proxied":   res.status == pb.Status_OK && res.ProcessId != res.localID,
+		"local":     res.replica != nil,
+		"revision":  res.Etcd.Revision,
+		"stale":     res.stale,
+		"fromProxy": res.fromProxyHeader,
+		"term":      res.primaryTerm,
+	}).Debug("resolved journal")
+}
+
+// primaryTerm returns the CreateRevision of the primary assignment among
+// |assignments|, or zero if there is none.
+func primaryTerm(assignments keyspace.KeyValues) int64 {
+	for _, kv := range assignments {
+		if kv.Decoded.(allocator.Assignment).Slot == 0 {
+			return kv.Raw.CreateRevision
+		}
+	}
+	return 0
+}
+
+// resolveSingleLocal is a fast-path of resolveLocked for the common case of a
+// journal having exactly one assignment, which is the primary and is served by
+// a local replica. The resolution is always OK, and its Route is built directly
+// from our own BrokerSpec rather than by mapping assignments through the
+// KeySpace. It returns false if the journal doesn't qualify, and |res| must
+// then be resolved through the general path.
+func (r *resolver) resolveSingleLocal(args resolveArgs, res *resolution) bool {
+	if res.journalSpec == nil || len(res.assignments) != 1 || r.state.LocalMemberInd == -1 {
+		return false
+	} else if !args.allowPendingDeletion && hasLabel(res.journalSpec, labels.PendingDeletion) {
+		return false // selectStatus rejects the resolution.
+	} else if args.requirePrimary && hasLabel(res.journalSpec, labels.Maintenance) {
+		return false // Likewise.
+	}
+	var replica = r.replicas[args.journal]
+	if replica == nil {
+		return false // Also the case if we've stopped serving local replicas.
+	}
+	var asn = res.assignments[0].Decoded.(allocator.Assignment)
+	if asn.Slot != 0 || asn.MemberZone != res.localID.Zone || asn.MemberSuffix != res.localID.Suffix {
+		return false
+	}
+	var spec = r.state.Members[r.state.LocalMemberInd].
+		Decoded.(allocator.Member).MemberValue.(*pb.BrokerSpec)
+
+	res.Route = pb.Route{
+		Members: []pb.ProcessSpec_ID{res.localID},
+		Primary: 0,
+	}
+	if !res.routeUnchanged {
+		res.Route.Endpoints = []pb.Endpoint{spec.Endpoint}
+	}
+	res.ProcessId = res.localID
+	res.replica = replica.replica
+	res.invalidateCh = replica.signalCh
+	res.recovering = replica.isRecovering()
+	res.status = pb.Status_OK
+
+	addTrace(args.ctx, "resolve(%s) => %s, local: %t, header: %s (single local)",
+		args.journal, res.status, true, &res.Header)
+
+	return true
 }
 
 // updateResolutions, by virtue of being a KeySpace.Observer, expects that the
 // KeySpace.Mu Lock is held.
 func (r *resolver) updateResolutions() {
+	// Cached not-found journals may have since been created.
+	r.notFound.reset()
+	r.lastUpdate = r.clock.Now()
+
 	if r.replicas == nil {
 		return // We've stopped serving local replicas.
 	}
 	var next = make(map[pb.Journal]*resolverReplica, len(r.state.LocalItems))
+	var states = make(map[string]int, 3)
 
 	for _, li := range r.state.LocalItems {
 		var item = li.Item.Decoded.(allocator.Item)
@@ -220,6 +978,16 @@ func (r *resolver) updateResolutions() {
 				replica:     r.newReplica(name), // Newly assigned journal.
 				assignments: li.Assignments.Copy(),
 				signalCh:    make(chan struct{}),
+				createdCh:   make(chan struct{}),
+			}
+			if created := r.onReplicaCreated; created == nil {
+				close(replica.createdCh)
+			} else {
+				// Invoke outside of the KeySpace critical section.
+				go func(rr *resolverReplica) {
+					created(rr.journal, rr.replica)
+					close(rr.createdCh)
+				}(replica)
 			}
 
 			var rt pb.Route
@@ -230,6 +998,10 @@ func (r *resolver) updateResolutions() {
 				"route": rt,
 			}).Info("starting local journal replica")
 
+			go observeRecovery(replica.replica, r.clock, r.clock.Now())
+
+			r.notifyWatchers(AssignmentAdded, name, li.Assignments)
+
 		} else {
 			delete(r.replicas, name)
 		}
@@ -239,7 +1011,14 @@ func (r *resolver) updateResolutions() {
 			close(replica.signalCh)
 			replica.signalCh = make(chan struct{})
 			replica.assignments = li.Assignments.Copy()
+
+			r.notifyWatchers(AssignmentRouteChanged, name, li.Assignments)
 		}
+		states[localReplicaState(li)]++
+	}
+	localReplicasGauge.Set(float64(len(next)))
+	for _, state := range []string{"recovering", "primary", "standby"} {
+		localReplicaStatesGauge.WithLabelValues(state).Set(float64(states[state]))
 	}
 
 	var prev = r.replicas
@@ -249,6 +1028,185 @@ func (r *resolver) updateResolutions() {
 	r.cancelReplicas(prev)
 }
 
+// localReplicaState returns the state of a LocalItem for metrics: "recovering"
+// if its assignments don't yet advertise a consistent Route, and otherwise
+// "primary" or "standby" according to the local assignment's slot.
+func localReplicaState(li allocator.LocalItem) string {
+	var rt pb.Route
+	pbx.Init(&rt, li.Assignments)
+
+	if !JournalRouteMatchesAssignments(rt, li.Assignments) {
+		return "recovering"
+	} else if li.Assignments[li.Index].Decoded.(allocator.Assignment).Slot == 0 {
+		return "primary"
+	}
+	return "standby"
+}
+
+// observeRecovery observes the duration of |rep|'s recovery, from |started|
+// until its fragment index completes an initial refresh, as measured by clock
+// |c|. A replica which is stopped before then isn't observed.
+func observeRecovery(rep *replica, c clock, started time.Time) {
+	select {
+	case <-rep.index.FirstRefreshCh():
+		replicaRecoverySeconds.Observe(c.Now().Sub(started).Seconds())
+	case <-rep.ctx.Done():
+	}
+}
+
+// setRoutePolicy replaces the RoutePolicy of the resolver. A nil |policy|
+// restores the default policy.
+func (r *resolver) setRoutePolicy(policy RoutePolicy) {
+	if policy == nil {
+		policy = anyMemberRoutePolicy{}
+	}
+	r.state.KS.Mu.Lock()
+	r.routePolicy = policy
+	r.state.KS.Mu.Unlock()
+}
+
+// invalidate cached resolutions of local replicas of |journals|, as if their
+// assignments had changed: the invalidateCh of each prior resolution is
+// closed, and RPCs awaiting it re-resolve. It's intended for tooling which
+// reconfigures journals and must ensure in-flight RPCs observe the change.
+// Journals lacking a local replica are ignored, as their resolutions have no
+// invalidateCh.
+func (r *resolver) invalidate(journals ...pb.Journal) {
+	var ks = r.state.KS
+	ks.Mu.Lock()
+	defer ks.Mu.Unlock()
+
+	for _, journal := range journals {
+		if replica, ok := r.replicas[journal.StripMeta()]; ok {
+			close(replica.signalCh)
+			replica.signalCh = make(chan struct{})
+		}
+	}
+}
+
+// setClock replaces the clock of the resolver. A nil |c| restores the
+// system clock.
+func (r *resolver) setClock(c clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	r.state.KS.Mu.Lock()
+	r.clock = c
+	r.state.KS.Mu.Unlock()
+}
+
+// setReplicaHooks installs hooks invoked with each local replica as it's
+// created, and after it's stopped, as by re-assignment of its journal or by
+// stopServingLocalReplicas. Hooks are invoked from their own goroutines,
+// never while the KeySpace lock is held, and may block. A replica's stopped
+// hook is invoked only after its created hook has returned, and the resolver's
+// replicas aren't considered stopped (per its |wg|) until it has. Either hook
+// may be nil. A created hook isn't invoked for replicas which already exist.
+func (r *resolver) setReplicaHooks(created, stopped func(pb.Journal, *replica)) {
+	r.state.KS.Mu.Lock()
+	r.onReplicaCreated, r.onReplicaStopped = created, stopped
+	r.state.KS.Mu.Unlock()
+}
+
+// localJournals returns the sorted names of journals having a local replica,
+// and the KeySpace revision at which they were read. Journals are empty if
+// stopServingLocalReplicas has been called.
+func (r *resolver) localJournals() ([]pb.Journal, int64) {
+	var ks = r.state.KS
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	var out = make([]pb.Journal, 0, len(r.replicas))
+	for name := range r.replicas {
+		out = append(out, name)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, ks.Header.Revision
+}
+
+// routeFor returns the current Route of |journal| and the Header of the
+// KeySpace revision it reflects, and whether the journal's JournalSpec exists.
+// Unlike resolve, it doesn't select a ProcessId or status, doesn't wait for a
+// revision, and never creates or attaches a local replica: it's a read-only
+// lookup of the KeySpace, intended for tooling such as topology dashboards.
+// The returned Header has only its Route and Etcd fields set.
+func (r *resolver) routeFor(journal pb.Journal) (pb.Route, pb.Header, bool) {
+	var ks = r.state.KS
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	var _, ok = allocator.LookupItem(ks, journal.String())
+	var hdr = pb.Header{Etcd: pbx.FromEtcdResponseHeader(ks.Header)}
+
+	pbx.Init(&hdr.Route, ks.KeyValues.Prefixed(allocator.ItemAssignmentsPrefix(ks, journal.String())))
+	pbx.AttachEndpoints(&hdr.Route, ks)
+
+	return hdr.Route, hdr, ok
+}
+
+// syncToRevision blocks until the resolver's KeySpace has applied Etcd
+// revision |rev|, or returns the error of |ctx|. Upon its return, every
+// resolution reflects all changes through |rev|. It allows callers which made
+// an Etcd change to await its observation, without racing it.
+func (r *resolver) syncToRevision(ctx context.Context, rev int64) error {
+	var ks = r.state.KS
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	return ks.WaitForRevision(ctx, rev)
+}
+
+// underReplicatedJournals returns the sorted names of journals which are
+// assigned to fewer live brokers than their desired replication, as of the
+// current KeySpace revision. A broker is live if its BrokerSpec (and thus its
+// Etcd lease) still exists. Journals having no live brokers at all resolve with
+// INSUFFICIENT_JOURNAL_BROKERS, while others are served with reduced
+// durability until the allocator assigns further brokers, if it can.
+func (r *resolver) underReplicatedJournals() []pb.Journal {
+	var ks = r.state.KS
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	var out []pb.Journal
+	for _, kv := range r.state.Items {
+		var item = kv.Decoded.(allocator.Item)
+		var spec = item.ItemValue.(*pb.JournalSpec)
+
+		var live int
+		for _, asn := range ks.KeyValues.Prefixed(allocator.ItemAssignmentsPrefix(ks, item.ID)) {
+			var a = asn.Decoded.(allocator.Assignment)
+			if _, ok := allocator.LookupMember(ks, a.MemberZone, a.MemberSuffix); ok {
+				live++
+			}
+		}
+		if live < spec.DesiredReplication() {
+			out = append(out, spec.Name)
+		}
+	}
+	return out
+}
+
+// Ready returns true if the resolver is ready to serve its local replicas:
+// its KeySpace has completed an initial load, and includes our own BrokerSpec.
+// It returns false while the initial load is in progress, and false with
+// errResolverStopped after stopServingLocalReplicas has been called.
+// Ready is intended to power readiness probes, which keep traffic from being
+// routed to a broker that can't yet resolve its assigned journals.
+func (r *resolver) Ready() (bool, error) {
+	var ks = r.state.KS
+	ks.Mu.RLock()
+	defer ks.Mu.RUnlock()
+
+	if r.replicas == nil {
+		return false, errResolverStopped
+	} else if ks.Header.Revision == 0 {
+		return false, nil // Initial KeySpace load is in progress.
+	} else if r.state.LocalMemberInd == -1 {
+		return false, nil // Our BrokerSpec isn't (yet) in the KeySpace.
+	}
+	return true, nil
+}
+
 // stopServingLocalReplicas begins immediate shutdown of any & all local
 // replicas, and causes future attempts to resolve to local replicas to
 // return an error.
@@ -258,6 +1216,112 @@ func (r *resolver) stopServingLocalReplicas() {
 
 	r.cancelReplicas(r.replicas)
 	r.replicas = nil
+
+	localReplicasGauge.Set(0)
+	localReplicaStatesGauge.Reset()
+
+	for w := range r.watchers {
+		w.stop()
+		delete(r.watchers, w)
+	}
+}
+
+// revisionLag returns the number of Etcd revisions by which the KeySpace
+// trails the Etcd cluster, as determined by a linearizable read of the
+// cluster's current revision. The lag is also reported by the
+// keySpaceRevisionLag gauge. A large lag predicts long waits of resolutions
+// for future revisions, such as those of proxied requests. Note that writes
+// to keys outside of the KeySpace also advance the cluster revision, and
+// the KeySpace doesn't observe them until its next watch update.
+func (r *resolver) revisionLag(ctx context.Context) (int64, error) {
+	if r.etcd == nil {
+		return 0, fmt.Errorf("resolver cannot read the Etcd revision without an Etcd client")
+	}
+	var ks = r.state.KS
+
+	var resp, err = r.etcd.Get(ctx, ks.Root, clientv3.WithCountOnly())
+	if err != nil {
+		return 0, errors.WithMessage(err, "reading current Etcd revision")
+	}
+
+	ks.Mu.RLock()
+	var lag = resp.Header.Revision - ks.Header.Revision
+	ks.Mu.RUnlock()
+
+	if lag < 0 {
+		lag = 0 // The KeySpace read through a later revision since our read.
+	}
+	keySpaceRevisionLag.Set(float64(lag))
+	return lag, nil
+}
+
+// drainForShutdown gracefully hands off local replicas before stopping them.
+// It zeros the JournalLimit of our BrokerSpec in Etcd, upon which allocators
+// work to move our assignments to peers, and then waits until we're no longer
+// the primary of any journal, so that peers have been elected in our stead.
+// Finally it stops serving local replicas. If |ctx| is done first, its error
+// is returned and local replicas are not stopped.
+//
+// Brokers of an allocator.StartSession already zero their limit on receipt of
+// its SignalCh. drainForShutdown is intended for embedding applications which
+// stop a broker by other means, and shouldn't be combined with a subsequent
+// signal: its Announcement would find the member key modified externally.
+func (r *resolver) drainForShutdown(ctx context.Context) error {
+	if r.etcd == nil {
+		return fmt.Errorf("resolver cannot drain without an Etcd client")
+	}
+	var ks = r.state.KS
+	ks.Mu.RLock()
+
+	if r.state.LocalMemberInd == -1 {
+		ks.Mu.RUnlock()
+		return fmt.Errorf("local BrokerSpec is missing from Etcd")
+	}
+	var member = r.state.Members[r.state.LocalMemberInd]
+	var spec = *member.Decoded.(allocator.Member).MemberValue.(*pb.BrokerSpec)
+	ks.Mu.RUnlock()
+
+	if spec.JournalLimit != 0 {
+		spec.ZeroLimit()
+
+		var key = string(member.Raw.Key)
+		var resp, err = r.etcd.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", member.Raw.ModRevision)).
+			Then(clientv3.OpPut(key, spec.MarshalString(), clientv3.WithIgnoreLease())).
+			Commit()
+
+		if err == nil && !resp.Succeeded {
+			err = fmt.Errorf("member key modified or deleted externally (expected revision %d)",
+				member.Raw.ModRevision)
+		}
+		if err != nil {
+			return errors.WithMessage(err, "zeroing JournalLimit")
+		}
+		log.WithField("id", spec.Id).Info("zeroed JournalLimit to drain local replicas")
+	}
+
+	ks.Mu.RLock()
+	for {
+		var primaries int
+		for _, li := range r.state.LocalItems {
+			if li.Assignments[li.Index].Decoded.(allocator.Assignment).Slot == 0 {
+				primaries++
+			}
+		}
+		if primaries == 0 {
+			break
+		}
+		log.WithField("primaries", primaries).Info("awaiting handoff of primary journals")
+
+		if err := ks.WaitForRevision(ctx, ks.Header.Revision+1); err != nil {
+			ks.Mu.RUnlock()
+			return err
+		}
+	}
+	ks.Mu.RUnlock()
+
+	r.stopServingLocalReplicas()
+	return nil
 }
 
 func (r *resolver) cancelReplicas(m map[pb.Journal]*resolverReplica) {
@@ -267,8 +1331,18 @@ func (r *resolver) cancelReplicas(m map[pb.Journal]*resolverReplica) {
 		// Close |signalCh| to unblock any Replicate or Append RPCs which would
 		// otherwise race shutDownReplica() to the |spoolCh| or |pipelineCh|.
 		close(replica.signalCh)
+		r.notifyWatchers(AssignmentRemoved, replica.journal, nil)
 
-		go shutDownReplica(replica.replica, r.wg.Done)
+		if stopped := r.onReplicaStopped; stopped == nil {
+			go shutDownReplica(replica.replica, r.wg.Done)
+		} else {
+			var rr = replica
+			go shutDownReplica(rr.replica, func() {
+				<-rr.createdCh // Order after the onReplicaCreated hook.
+				stopped(rr.journal, rr.replica)
+				r.wg.Done()
+			})
+		}
 	}
 }
 
@@ -280,4 +1354,285 @@ func (r *resolver) watch(ctx context.Context, etcd *clientv3.Client) error {
 	return err
 }
 
-var errResolverStopped = errors.New("resolver has stopped serving local replicas")
+// AssignmentEventType is the type of an AssignmentEvent.
+type AssignmentEventType int
+
+const (
+	// AssignmentAdded is a journal newly assigned to this broker.
+	AssignmentAdded AssignmentEventType = iota
+	// AssignmentRemoved is a journal no longer assigned to this broker, or
+	// whose local replica was stopped.
+	AssignmentRemoved
+	// AssignmentRouteChanged is a locally assigned journal whose assignments
+	// (and potentially its Route) have changed.
+	AssignmentRouteChanged
+)
+
+// AssignmentEvent is a change of a journal for which this broker is responsible.
+type AssignmentEvent struct {
+	Type    AssignmentEventType
+	Journal pb.Journal
+	// Route of the journal's current assignments, with attached Endpoints.
+	// It's empty for AssignmentRemoved events.
+	Route pb.Route
+	// Etcd revision of the KeySpace at which the event was observed.
+	Revision int64
+}
+
+// WatchAssignments returns a channel of AssignmentEvents for journals assigned
+// to this broker. It begins with an AssignmentAdded event for each journal
+// presently assigned, and then delivers changes in the order they're observed.
+// Events are queued without bound while awaiting the reader, and are never
+// dropped. The channel is closed when |ctx| is cancelled, or after delivering
+// AssignmentRemoved events of all local journals once the resolver stops
+// serving local replicas.
+func (r *resolver) WatchAssignments(ctx context.Context) <-chan AssignmentEvent {
+	var ks = r.state.KS
+	var w = &assignmentWatcher{signalCh: make(chan struct{}, 1)}
+	var out = make(chan AssignmentEvent)
+
+	ks.Mu.Lock()
+	if r.replicas == nil {
+		ks.Mu.Unlock()
+		close(out)
+		return out
+	}
+	var names = make([]pb.Journal, 0, len(r.replicas))
+	for name := range r.replicas {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	for _, name := range names {
+		w.push(r.assignmentEvent(AssignmentAdded, name, r.replicas[name].assignments))
+	}
+	r.watchers[w] = struct{}{}
+	ks.Mu.Unlock()
+
+	go func() {
+		defer close(out)
+		defer func() {
+			ks.Mu.Lock()
+			delete(r.watchers, w)
+			ks.Mu.Unlock()
+		}()
+
+		for {
+			var events, stopped = w.pop()
+
+			for _, event := range events {
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if stopped {
+				return
+			}
+			select {
+			case <-w.signalCh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// notifyWatchers of an AssignmentEvent. The KeySpace lock must be held.
+func (r *resolver) notifyWatchers(typ AssignmentEventType, journal pb.Journal, assignments keyspace.KeyValues) {
+	if len(r.watchers) == 0 {
+		return
+	}
+	var event = r.assignmentEvent(typ, journal, assignments)
+	for w := range r.watchers {
+		w.push(event)
+	}
+}
+
+func (r *resolver) assignmentEvent(typ AssignmentEventType, journal pb.Journal, assignments keyspace.KeyValues) AssignmentEvent {
+	var event = AssignmentEvent{
+		Type:     typ,
+		Journal:  journal,
+		Revision: r.state.KS.Header.Revision,
+	}
+	if typ != AssignmentRemoved {
+		pbx.Init(&event.Route, assignments)
+		pbx.AttachEndpoints(&event.Route, r.state.KS)
+	}
+	return event
+}
+
+// assignmentWatcher queues AssignmentEvents of a WatchAssignments caller.
+// Events are pushed by KeySpace observers which must not block, and are
+// popped by a goroutine which delivers them to the caller.
+type assignmentWatcher struct {
+	mu       sync.Mutex
+	events   []AssignmentEvent
+	stopped  bool
+	signalCh chan struct{} // Buffered, of size one.
+}
+
+func (w *assignmentWatcher) push(event AssignmentEvent) {
+	w.mu.Lock()
+	w.events = append(w.events, event)
+	w.mu.Unlock()
+	w.signal()
+}
+
+func (w *assignmentWatcher) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+	w.signal()
+}
+
+func (w *assignmentWatcher) signal() {
+	select {
+	case w.signalCh <- struct{}{}:
+	default: // Already signaled.
+	}
+}
+
+// pop all queued events, and whether the watcher has stopped.
+func (w *assignmentWatcher) pop() ([]AssignmentEvent, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var events = w.events
+	w.events = nil
+	return events, w.stopped
+}
+
+// notFoundCache is a short-lived, negative cache of journals which don't exist
+// (and have no assignments) as of a specific KeySpace revision. It spares
+// repeated KeySpace lookups by clients which poll for a journal before it's
+// created. Entries are valid only at the revision of their insertion, and
+// the cache is reset on every KeySpace update, so a journal which is created
+// at a later revision is never masked.
+type notFoundCache struct {
+	mu       sync.Mutex
+	revision int64
+	expires  map[pb.Journal]time.Time
+}
+
+// contains returns whether |journal| is cached as not found at |revision|,
+// as of time |now|.
+func (c *notFoundCache) contains(journal pb.Journal, revision int64, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.revision != revision {
+		return false
+	}
+	var expires, ok = c.expires[journal]
+	return ok && now.Before(expires)
+}
+
+// add |journal| to the cache as not found at |revision|, as of time |now|.
+func (c *notFoundCache) add(journal pb.Journal, revision int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.revision != revision || c.expires == nil {
+		c.revision, c.expires = revision, make(map[pb.Journal]time.Time)
+	}
+	c.expires[journal] = now.Add(notFoundCacheTTL)
+}
+
+// reset the cache, discarding all entries.
+func (c *notFoundCache) reset() {
+	c.mu.Lock()
+	c.revision, c.expires = 0, nil
+	c.mu.Unlock()
+}
+
+// routeGenerations tracks a per-journal route generation, which increases with
+// each change to the journal's assignments and allows for cheap comparisons
+// of whether a journal's Route may have changed.
+//
+// A generation is derived from Etcd as the maximum ModRevision of the journal's
+// assignments, making it consistent across brokers and restarts. However, the
+// removal of an assignment doesn't increase the maximum ModRevision of those
+// which remain. routeGenerations detects such changes between successive
+// resolutions of a journal, and instead attributes them to the current
+// KeySpace revision. Journals which don't exist aren't tracked. Note that
+// generations also increase as assignments are updated to reflect their
+// replica consistency.
+type routeGenerations struct {
+	mu sync.Mutex
+	m  map[pb.Journal]routeGeneration
+}
+
+type routeGeneration struct {
+	generation  int64
+	assignments keyspace.KeyValues
+	// Time at which the generation was first observed.
+	observedAt time.Time
+}
+
+// observe |assignments| of |journal| at KeySpace |revision| and time |now|,
+// returning the journal's current route generation and the time at which it
+// was first observed. |exists| is whether the journal has a JournalSpec at
+// |revision|.
+func (g *routeGenerations) observe(journal pb.Journal, exists bool, assignments keyspace.KeyValues, revision int64, now time.Time) (int64, time.Time) {
+	var gen int64
+	for _, kv := range assignments {
+		if kv.Raw.ModRevision > gen {
+			gen = kv.Raw.ModRevision
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var prev, ok = g.m[journal]
+	if ok && prev.assignments.EqualKeyRevisions(assignments) {
+		return prev.generation, prev.observedAt // Unchanged.
+	} else if ok && gen <= prev.generation {
+		// Assignments were removed, without a change to those which remain.
+		// The change happened no later than the current |revision|, which
+		// must be greater than the revision of our previous observation.
+		gen = revision
+	}
+
+	if !exists {
+		delete(g.m, journal)
+	} else {
+		if g.m == nil {
+			g.m = make(map[pb.Journal]routeGeneration)
+		}
+		g.m[journal] = routeGeneration{generation: gen, assignments: assignments, observedAt: now}
+	}
+	return gen, now
+}
+
+// suggestedRouteCacheTTL returns the duration for which a client may cache a
+// Route which has been stable for |age|. A Route which has been stable for a
+// while is likely to remain so, while one which recently changed may be
+// mid-way through a series of changes (eg, an election followed by replica
+// re-assignments). The TTL is half of |age|, bounded to
+// [minRouteCacheTTL, maxRouteCacheTTL].
+func suggestedRouteCacheTTL(age time.Duration) time.Duration {
+	var ttl = age / 2
+	if ttl < minRouteCacheTTL {
+		ttl = minRouteCacheTTL
+	} else if ttl > maxRouteCacheTTL {
+		ttl = maxRouteCacheTTL
+	}
+	return ttl
+}
+
+// Bounds of resolution.suggestedCacheTTL.
+var minRouteCacheTTL, maxRouteCacheTTL = time.Second, 5 * time.Minute
+
+// notFoundCacheTTL bounds the duration for which a journal is cached as not found.
+var notFoundCacheTTL = time.Second
+
+var (
+	errResolverStopped    = errors.New("resolver has stopped serving local replicas")
+	errRevisionNotReached = errors.New("minimum Etcd revision hasn't been read through")
+	errNotReady           = errors.New("resolver KeySpace hasn't completed its initial load")
+	errNoLocalReplica     = errors.New("journal has no local replica")
+)
@@ -2,13 +2,22 @@ package broker
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
+	"go.gazette.dev/core/allocator"
 	pb "go.gazette.dev/core/broker/protocol"
 	pbx "go.gazette.dev/core/broker/protocol/ext"
 	"go.gazette.dev/core/etcdtest"
+	"go.gazette.dev/core/labels"
 )
 
 func TestResolveCases(t *testing.T) {
@@ -50,6 +59,13 @@ func TestResolveCases(t *testing.T) {
 
 	// Expect a replica was created for each journal |broker| is responsible for.
 	require.Len(t, resolver.replicas, 3)
+	require.Equal(t, 3.0, testutil.ToFloat64(localReplicasGauge))
+	// Test assignments don't advertise Routes, and all replicas are recovering.
+	require.Equal(t, 3.0, testutil.ToFloat64(localReplicaStatesGauge.WithLabelValues("recovering")))
+	require.Equal(t, 0.0, testutil.ToFloat64(localReplicaStatesGauge.WithLabelValues("primary")))
+	var local, rev = resolver.localJournals()
+	require.Equal(t, []pb.Journal{"no/primary/journal", "primary/journal", "replica/journal"}, local)
+	require.Equal(t, broker.ks.Header.Revision, rev)
 
 	// Case: simple resolution of local replica.
 	var r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "replica/journal;ignored/meta"})
@@ -72,6 +88,8 @@ func TestResolveCases(t *testing.T) {
 	require.Equal(t, pb.Status_OK, r.status)
 	require.Equal(t, broker.id, r.Header.ProcessId)
 	require.Equal(t, mkRoute(0, broker.id, peer.id), r.Header.Route)
+	require.True(t, r.HasPrimary())
+	require.True(t, r.IsPrimaryLocal())
 
 	// Case: primary is required, we are not primary, and may not proxy.
 	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "replica/journal", requirePrimary: true})
@@ -90,6 +108,8 @@ func TestResolveCases(t *testing.T) {
 	// The resolution is specifically to |peer|.
 	require.Equal(t, peer.id, r.Header.ProcessId)
 	require.Equal(t, mkRoute(1, broker.id, peer.id), r.Header.Route)
+	require.True(t, r.HasPrimary())
+	require.False(t, r.IsPrimaryLocal())
 	// Replica is also attached.
 	require.NotNil(t, r.replica)
 
@@ -100,6 +120,10 @@ func TestResolveCases(t *testing.T) {
 	require.Equal(t, broker.id, r.Header.ProcessId) // We authored the error.
 	require.Equal(t, mkRoute(-1, broker.id, peer.id), r.Header.Route)
 	require.NotNil(t, r.replica)
+	require.False(t, r.HasPrimary())
+	// |peer| could serve a read. Our own replica is still recovering.
+	require.Equal(t, []pb.ProcessSpec_ID{peer.id}, r.readPeers)
+	require.False(t, r.IsPrimaryLocal())
 
 	// Case: we may not proxy, and are not a replica.
 	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/only/journal"})
@@ -128,13 +152,32 @@ func TestResolveCases(t *testing.T) {
 	require.Equal(t, broker.id, r.Header.ProcessId)
 	require.Equal(t, mkRoute(-1), r.Header.Route)
 
+	// Case: raw Routes of local, peer, and missing journals.
+	var replicas = len(resolver.replicas)
+	for _, tc := range []struct {
+		journal pb.Journal
+		route   pb.Route
+		exists  bool
+	}{
+		{"primary/journal", mkRoute(0, broker.id, peer.id), true},
+		{"peer/only/journal", mkRoute(0, peer.id), true},
+		{"no/brokers/journal", mkRoute(-1), true},
+		{"does/not/exist", mkRoute(-1), false},
+	} {
+		var rt, hdr, ok = resolver.routeFor(tc.journal)
+		require.Equal(t, tc.exists, ok)
+		require.Equal(t, tc.route, rt)
+		require.Equal(t, tc.route, hdr.Route)
+		require.Equal(t, broker.ks.Header.Revision, hdr.Etcd.Revision)
+		require.Equal(t, pb.ProcessSpec_ID{}, hdr.ProcessId)
+	}
+	require.Len(t, resolver.replicas, replicas) // No replicas were created.
+
 	// Case: our broker key has been removed.
 	var resp, err = etcd.Delete(ctx, resolver.state.LocalKey)
 	require.NoError(t, err)
 
-	broker.ks.Mu.RLock()
-	require.NoError(t, broker.ks.WaitForRevision(ctx, resp.Header.Revision))
-	broker.ks.Mu.RUnlock()
+	require.NoError(t, broker.svc.resolver.syncToRevision(ctx, resp.Header.Revision))
 
 	// Subcase 1: We can still resolve for peer journals.
 	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/only/journal", mayProxy: true})
@@ -169,12 +212,17 @@ func TestResolverLocalReplicaStopping(t *testing.T) {
 	require.NotNil(t, r.replica)
 	require.NoError(t, r.replica.ctx.Err())
 
+	var local, _ = broker.svc.resolver.localJournals()
+	require.Equal(t, []pb.Journal{"a/journal"}, local)
 	broker.svc.resolver.stopServingLocalReplicas()
 
 	// Expect a route invalidation occurred immediately, to wake any awaiting RPCs.
 	<-r.invalidateCh
 	// And that the replica is then shut down.
 	<-r.replica.ctx.Done()
+	// We no longer report any local journals.
+	local, _ = broker.svc.resolver.localJournals()
+	require.Empty(t, local)
 
 	// Attempts to resolve a local journal fail.
 	var _, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
@@ -202,6 +250,265 @@ func TestResolverLocalReplicaStopping(t *testing.T) {
 	peer.Cleanup()
 }
 
+func TestResolveSingleLocalReplica(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+
+	// The Route which the general path builds from the journal's assignments.
+	var expect pb.Route
+	broker.ks.Mu.RLock()
+	pbx.Init(&expect, broker.ks.KeyValues.Prefixed(
+		allocator.ItemAssignmentsPrefix(broker.ks, "a/journal")))
+	pbx.AttachEndpoints(&expect, broker.ks)
+	broker.ks.Mu.RUnlock()
+
+	require.Equal(t, pb.Route{
+		Members:   []pb.ProcessSpec_ID{broker.id},
+		Primary:   0,
+		Endpoints: []pb.Endpoint{broker.srv.Endpoint()},
+	}, expect)
+
+	// Resolutions are identical under all combinations of arguments.
+	for _, args := range []resolveArgs{
+		{ctx: ctx, journal: "a/journal"},
+		{ctx: ctx, journal: "a/journal;meta", mayProxy: true},
+		{ctx: ctx, journal: "a/journal", requirePrimary: true},
+		{ctx: ctx, journal: "a/journal", requirePrimary: true, mayProxy: true},
+	} {
+		var r, err = broker.svc.resolver.resolve(args)
+		require.NoError(t, err)
+		require.Equal(t, pb.Status_OK, r.status)
+		require.Equal(t, broker.id, r.Header.ProcessId)
+		require.Equal(t, expect, r.Header.Route)
+		require.Equal(t, pbx.FromEtcdResponseHeader(broker.ks.Header), r.Header.Etcd)
+		require.Equal(t, broker.svc.resolver.replicas["a/journal"].replica, r.replica)
+		require.NotNil(t, r.invalidateCh)
+		require.Len(t, r.assignments, 1)
+		require.Equal(t, pb.Journal("a/journal"), r.journalSpec.Name)
+	}
+
+	// Once local replicas are stopped, the fast path no longer applies.
+	broker.svc.resolver.stopServingLocalReplicas()
+	var _, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+	require.Equal(t, errResolverStopped, err)
+
+	broker.cleanup()
+}
+
+func TestResolverSyncToRevision(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var resolver = broker.svc.resolver
+
+	// Create a journal directly in Etcd, without awaiting its observation.
+	var spec = pb.JournalSpec{Name: "a/journal", Replication: 1,
+		Fragment: pb.JournalSpec_Fragment{
+			Length:           1024,
+			RefreshInterval:  time.Second,
+			CompressionCodec: pb.CompressionCodec_NONE,
+		}}
+	require.NoError(t, spec.Validate())
+
+	var resp, err = etcd.Put(ctx, allocator.ItemKey(broker.ks, spec.Name.String()), spec.MarshalString())
+	require.NoError(t, err)
+
+	// Once synced to the revision, the journal is resolved.
+	require.NoError(t, resolver.syncToRevision(ctx, resp.Header.Revision))
+	var r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", mayProxy: true})
+	require.Equal(t, &spec, r.journalSpec)
+	require.Equal(t, resp.Header.Revision, r.Etcd.Revision)
+
+	// Syncing to a revision which doesn't come about returns the context error.
+	var timeoutCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	require.Equal(t, context.DeadlineExceeded,
+		resolver.syncToRevision(timeoutCtx, resp.Header.Revision+1e6))
+	cancel()
+
+	broker.cleanup()
+}
+
+func TestResolverUnderReplicatedJournals(t *testing.T) {
+	var etcd = etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+	var missing = pb.ProcessSpec_ID{Zone: "missing", Suffix: "broker"}
+
+	setTestJournal(broker, pb.JournalSpec{Name: "replicated/journal", Replication: 2}, broker.id, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "under/journal", Replication: 2}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "no/brokers/journal", Replication: 1})
+	// An assignment to a broker which no longer exists isn't counted.
+	setTestJournal(broker, pb.JournalSpec{Name: "missing/journal", Replication: 2}, peer.id, missing)
+
+	require.Equal(t, []pb.Journal{"missing/journal", "no/brokers/journal", "under/journal"},
+		broker.svc.resolver.underReplicatedJournals())
+
+	// The under-replicated journal is assigned a second broker.
+	setTestJournal(broker, pb.JournalSpec{Name: "under/journal", Replication: 2}, broker.id, peer.id)
+	require.Equal(t, []pb.Journal{"missing/journal", "no/brokers/journal"},
+		broker.svc.resolver.underReplicatedJournals())
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolverReplicaRecoveryMetric(t *testing.T) {
+	var etcd = etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var observed = func() (count uint64, sum float64) {
+		var m dto.Metric
+		require.NoError(t, replicaRecoverySeconds.Write(&m))
+		return m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum()
+	}
+	var count, sum = observed()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var clock = newTestClock(time.Now())
+	broker.svc.resolver.setClock(clock)
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+
+	// The replica is recovering, and isn't yet observed.
+	clock.advance(10 * time.Second)
+	require.True(t, broker.replica("a/journal").isRecovering())
+	var c, _ = observed()
+	require.Equal(t, count, c)
+
+	// Its recovery completes, and is observed.
+	broker.initialFragmentLoad()
+	require.Eventually(t, func() bool {
+		var c, _ = observed()
+		return c > count
+	}, time.Second, time.Millisecond)
+
+	var _, s = observed()
+	require.InDelta(t, (10 * time.Second).Seconds(), s-sum, 1e-9)
+
+	broker.cleanup()
+}
+
+func TestResolverReplicaHooks(t *testing.T) {
+	var etcd = etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var mu sync.Mutex
+	var created, stopped []pb.Journal
+	var record = func(out *[]pb.Journal) func(pb.Journal, *replica) {
+		return func(journal pb.Journal, rep *replica) {
+			require.Equal(t, journal, rep.journal)
+
+			mu.Lock()
+			*out = append(*out, journal)
+			mu.Unlock()
+		}
+	}
+	var sorted = func(journals []pb.Journal) []pb.Journal {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var out = append([]pb.Journal(nil), journals...)
+		sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+		return out
+	}
+	broker.svc.resolver.setReplicaHooks(record(&created), record(&stopped))
+
+	// Use the journals of the TestResolveCases fixture.
+	setTestJournal(broker, pb.JournalSpec{Name: "primary/journal", Replication: 2},
+		broker.id, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "replica/journal", Replication: 2},
+		peer.id, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "no/primary/journal", Replication: 2},
+		pb.ProcessSpec_ID{}, broker.id, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/only/journal", Replication: 1},
+		peer.id)
+
+	var local = []pb.Journal{"no/primary/journal", "primary/journal", "replica/journal"}
+	require.Eventually(t, func() bool { return len(sorted(created)) == 3 }, time.Second, time.Millisecond)
+	require.Equal(t, local, sorted(created))
+	require.Empty(t, sorted(stopped))
+
+	// Case: a journal is re-assigned away from us.
+	setTestJournal(broker, pb.JournalSpec{Name: "replica/journal", Replication: 1}, peer.id)
+	require.Eventually(t, func() bool { return len(sorted(stopped)) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, []pb.Journal{"replica/journal"}, sorted(stopped))
+
+	// Case: we stop serving local replicas. Once the resolver's replicas have
+	// stopped, so have all hooks.
+	broker.svc.resolver.stopServingLocalReplicas()
+	broker.svc.resolver.wg.Wait()
+	require.Equal(t, local, sorted(stopped))
+	require.Equal(t, local, sorted(created))
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolverReady(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	// Case: the initial KeySpace load hasn't happened.
+	var ks = NewKeySpace("/broker.test")
+	var state = allocator.NewObservedState(ks,
+		allocator.MemberKey(ks, "local", "broker"), JournalIsConsistent)
+	var resolver = newResolver(state, newReplica)
+
+	var ready, err = resolver.Ready()
+	require.False(t, ready)
+	require.NoError(t, err)
+
+	// Resolutions fail with errNotReady, rather than a false JOURNAL_NOT_FOUND.
+	_, err = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", nonBlocking: true})
+	require.Equal(t, errNotReady, err)
+
+	var timeoutCtx, cancel = context.WithTimeout(ctx, time.Millisecond)
+	_, err = resolver.resolve(resolveArgs{ctx: timeoutCtx, journal: "a/journal"})
+	require.Equal(t, errNotReady, err)
+	cancel()
+
+	// A blocking resolution awaits the initial load.
+	var resCh = make(chan *resolution)
+	go func() {
+		var r, err = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+		require.NoError(t, err)
+		resCh <- r
+	}()
+
+	// Case: the KeySpace is loaded, but our BrokerSpec doesn't exist.
+	require.NoError(t, ks.Load(ctx, etcd, 0))
+	// The journal now (correctly) doesn't exist.
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, (<-resCh).status)
+
+	ready, err = resolver.Ready()
+	require.False(t, ready)
+	require.NoError(t, err)
+
+	// Case: a loaded broker serving local replicas is ready.
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+
+	ready, err = broker.svc.Ready()
+	require.True(t, ready)
+	require.NoError(t, err)
+
+	// Case: the broker has stopped serving local replicas.
+	broker.svc.resolver.stopServingLocalReplicas()
+	ready, err = broker.svc.Ready()
+	require.False(t, ready)
+	require.Equal(t, errResolverStopped, err)
+
+	broker.cleanup()
+}
+
 func TestResolveFutureRevisionCasesWithProxyHeader(t *testing.T) {
 	var ctx, etcd = context.Background(), etcdtest.TestClient()
 	defer etcdtest.Cleanup()
@@ -231,6 +538,7 @@ func TestResolveFutureRevisionCasesWithProxyHeader(t *testing.T) {
 	var r, _ = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "journal/one", proxyHeader: &hdr})
 	require.Equal(t, pb.Status_OK, r.status)
 	require.Equal(t, hdr, r.Header)
+	require.True(t, r.fromProxyHeader)
 
 	// Case: this time, specify a future revision via |minEtcdRevision|. Expect that also works.
 	var futureRevision = broker.ks.Header.Revision + 1
@@ -241,6 +549,7 @@ func TestResolveFutureRevisionCasesWithProxyHeader(t *testing.T) {
 	r, _ = broker.svc.resolver.resolve(
 		resolveArgs{ctx: ctx, journal: "journal/two", minEtcdRevision: futureRevision})
 	require.Equal(t, pb.Status_OK, r.status)
+	require.False(t, r.fromProxyHeader)
 
 	// Case: finally, specify a future revision which doesn't come about and cancel the context.
 	ctx, cancel := context.WithCancel(ctx)
@@ -253,27 +562,1584 @@ func TestResolveFutureRevisionCasesWithProxyHeader(t *testing.T) {
 	broker.cleanup()
 }
 
-func TestResolveProxyHeaderErrorCases(t *testing.T) {
+func TestResolveFragmentStores(t *testing.T) {
 	var ctx, etcd = context.Background(), etcdtest.TestClient()
 	defer etcdtest.Cleanup()
 
 	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
 
-	var proxy = pb.Header{
-		ProcessId: pb.ProcessSpec_ID{Zone: "other", Suffix: "id"},
+	var stores = []pb.FragmentStore{"s3://a-bucket/path/", "gs://another-bucket/"}
+	setTestJournal(broker, pb.JournalSpec{
+		Name:        "with/stores",
+		Replication: 1,
+		Fragment:    pb.JournalSpec_Fragment{Stores: stores},
+	}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "without/stores", Replication: 1}, broker.id)
+
+	// Case: stores of the JournalSpec are available from the resolution.
+	var r, _ = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "with/stores"})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, stores, r.journalSpec.Fragment.Stores)
+
+	// Case: the JournalSpec has no stores.
+	r, _ = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "without/stores"})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Empty(t, r.journalSpec.Fragment.Stores)
+
+	// Case: the journal doesn't exist, and there's no JournalSpec.
+	r, _ = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "does/not/exist"})
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+	require.Nil(t, r.journalSpec)
+
+	broker.cleanup()
+}
+
+func TestResolveWaitDeadline(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	setTestJournal(broker, pb.JournalSpec{Name: "journal/one", Replication: 1}, broker.id)
+
+	// Case: specify a future revision which doesn't come about, with a short
+	// |waitDeadline|. Expect we resolve against the current revision, and the
+	// resolution is marked as stale.
+	var futureRevision = broker.ks.Header.Revision + 1e10
+
+	var r, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:             ctx,
+		journal:         "journal/one",
+		minEtcdRevision: futureRevision,
+		waitDeadline:    time.Now().Add(time.Millisecond),
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.Header.ProcessId)
+	require.Equal(t, pbx.FromEtcdResponseHeader(broker.ks.Header), r.Header.Etcd)
+	require.Less(t, r.Header.Etcd.Revision, futureRevision)
+	require.True(t, r.stale)
+
+	// Case: the primary is required. We don't serve it from a stale
+	// resolution, and instead fail with the elapsed deadline.
+	_, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:             ctx,
+		journal:         "journal/one",
+		requirePrimary:  true,
+		minEtcdRevision: futureRevision,
+		waitDeadline:    time.Now().Add(time.Millisecond),
+	})
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Case: the request was proxied from a peer which has read through a future
+	// revision. We also don't serve it from a stale resolution.
+	var hdr = pb.Header{
+		ProcessId: broker.id,
 		Route:     pb.Route{Primary: -1},
 		Etcd:      pbx.FromEtcdResponseHeader(broker.ks.Header),
 	}
+	hdr.Etcd.Revision = futureRevision
 
-	// Case: proxy header references a broker other than this one.
-	var _, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", proxyHeader: &proxy})
-	require.Regexp(t, `proxied request ProcessId doesn't match our own \(zone.*`, err)
-	proxy.ProcessId = broker.id
+	_, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:          ctx,
+		journal:      "journal/one",
+		mayProxy:     true,
+		proxyHeader:  &hdr,
+		waitDeadline: time.Now().Add(time.Millisecond),
+	})
+	require.Equal(t, context.DeadlineExceeded, err)
 
-	// Case: proxy header references a ClusterId other than our own.
-	proxy.Etcd.ClusterId = 8675309
-	_, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", proxyHeader: &proxy})
-	require.Regexp(t, `proxied request Etcd ClusterId doesn't match our own \(\d+.*`, err)
+	// Case: the revision is already read through. The resolution isn't stale.
+	r, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:             ctx,
+		journal:         "journal/one",
+		minEtcdRevision: broker.ks.Header.Revision,
+		waitDeadline:    time.Now().Add(time.Millisecond),
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.False(t, r.stale)
+
+	// Case: the context is cancelled prior to |waitDeadline|. The context wins.
+	ctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(time.Millisecond, cancel)
+
+	_, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:             ctx,
+		journal:         "journal/one",
+		minEtcdRevision: futureRevision,
+		waitDeadline:    time.Now().Add(time.Hour),
+	})
+	require.Equal(t, context.Canceled, err)
+
+	// Case: under a test clock, |waitDeadline| elapses as the clock advances,
+	// and not with the passage of real time.
+	var clock = newTestClock(time.Now())
+	broker.svc.resolver.setClock(clock)
+
+	type result struct {
+		r   *resolution
+		err error
+	}
+	var resultCh = make(chan result)
+
+	go func() {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{
+			ctx:             context.Background(),
+			journal:         "journal/one",
+			minEtcdRevision: futureRevision,
+			waitDeadline:    clock.Now().Add(time.Minute),
+		})
+		resultCh <- result{r, err}
+	}()
+
+	// Expect resolve() arms a timer of its deadline, and remains blocked
+	// until the deadline is reached.
+	require.Eventually(t, func() bool { return clock.pending() == 1 }, time.Second, time.Millisecond)
+	clock.advance(time.Second)
+	require.Equal(t, 1, clock.pending())
+	clock.advance(time.Minute)
+
+	var res = <-resultCh
+	require.NoError(t, res.err)
+	require.Equal(t, pb.Status_OK, res.r.status)
+	require.True(t, res.r.stale)
+	require.Equal(t, 0, clock.pending())
+
+	broker.cleanup()
+}
+
+func TestResolveTolerateStale(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	setTestJournal(broker, pb.JournalSpec{Name: "journal/one", Replication: 1}, broker.id)
+
+	var futureRevision = broker.ks.Header.Revision + 1e10
+	var args = resolveArgs{
+		journal:         "journal/one",
+		minEtcdRevision: futureRevision,
+		tolerateStale:   time.Minute,
+	}
+
+	// Case: the KeySpace was recently updated. We block for the revision.
+	var waitCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	args.ctx = waitCtx
+	var _, err = broker.svc.resolver.resolve(args)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Case: the KeySpace has stalled for longer than |tolerateStale|. We
+	// immediately resolve at the last-known revision, marked as stale.
+	var clock = newTestClock(time.Now())
+	broker.svc.resolver.setClock(clock)
+	clock.advance(2 * time.Minute)
+
+	args.ctx = ctx
+	r, err := broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.Header.ProcessId)
+	require.Equal(t, pbx.FromEtcdResponseHeader(broker.ks.Header), r.Header.Etcd)
+	require.True(t, r.stale)
+
+	// Case: the minimum revision is already read through. The resolution is current.
+	args.minEtcdRevision = broker.ks.Header.Revision
+	r, err = broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.False(t, r.stale)
+
+	// Case: the primary is required. We still block, despite the stall.
+	args.minEtcdRevision, args.requirePrimary, args.ctx = futureRevision, true, waitCtx
+	_, err = broker.svc.resolver.resolve(args)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	broker.cleanup()
+}
+
+func TestResolveNonBlocking(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+
+	var args = resolveArgs{
+		ctx:             ctx,
+		journal:         "a/journal",
+		minEtcdRevision: broker.ks.Header.Revision + 1e6,
+		nonBlocking:     true,
+	}
+	// Case: a far-future revision fails immediately.
+	var _, err = broker.svc.resolver.resolve(args)
+	require.Equal(t, errRevisionNotReached, err)
+
+	// Case: a revision which has been read through resolves as usual.
+	args.minEtcdRevision = broker.ks.Header.Revision
+	r, err := broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.False(t, r.stale)
+
+	broker.cleanup()
+}
+
+func TestResolveAtEtcdRevision(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+	var prior = broker.ks.Header.Revision
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, peer.id, broker.id)
+	var current = broker.ks.Header.Revision
+
+	var resolve = func(at int64) (*resolution, error) {
+		return broker.svc.resolver.resolve(resolveArgs{
+			ctx:            ctx,
+			journal:        "a/journal",
+			mayProxy:       true,
+			atEtcdRevision: at,
+		})
+	}
+
+	// Case: resolve at the current revision.
+	var r, err = resolve(current)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), r.journalSpec.Replication)
+	require.Equal(t, current, r.Etcd.Revision)
+	require.NotNil(t, r.replica)
+
+	// Case: resolve at the prior revision. The old spec and Route are returned.
+	r, err = resolve(prior)
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, int32(1), r.journalSpec.Replication)
+	require.Equal(t, prior, r.Etcd.Revision)
+	require.Equal(t, pb.Route{
+		Members:   []pb.ProcessSpec_ID{broker.id},
+		Primary:   0,
+		Endpoints: []pb.Endpoint{broker.srv.Endpoint()},
+	}, r.Route)
+	require.Equal(t, broker.id, r.ProcessId)
+	require.Nil(t, r.replica)
+
+	// Case: the prior revision is compacted.
+	_, err = etcd.Compact(ctx, current)
+	require.NoError(t, err)
+	_, err = resolve(prior)
+	require.Regexp(t, "loading KeySpace at revision .*: .*compacted", err)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveSnapshot(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "b/journal", Replication: 1}, peer.id)
+
+	var snap, err = broker.svc.resolver.snapshot()
+	require.NoError(t, err)
+	require.Equal(t, broker.ks.Header.Revision, snap.revision)
+
+	var resolve = func(journal pb.Journal) *resolution {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{
+			ctx:      ctx,
+			journal:  journal,
+			mayProxy: true,
+			snapshot: snap,
+		})
+		require.NoError(t, err)
+		require.Equal(t, pb.Status_OK, r.status)
+		return r
+	}
+
+	// Case: resolve both journals at the snapshot, which is current.
+	require.Equal(t, snap.revision, resolve("a/journal").Etcd.Revision)
+	require.Equal(t, snap.revision, resolve("b/journal").Etcd.Revision)
+	require.Nil(t, snap.hist)
+
+	// Swap the journals' brokers. Resolutions under the snapshot still see
+	// its revision and Routes, from a single loaded KeySpace.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "b/journal", Replication: 1}, broker.id)
+
+	var a, b = resolve("a/journal"), resolve("b/journal")
+	require.Equal(t, snap.revision, a.Etcd.Revision)
+	require.Equal(t, snap.revision, b.Etcd.Revision)
+	require.Equal(t, []pb.ProcessSpec_ID{broker.id}, a.Route.Members)
+	require.Equal(t, []pb.ProcessSpec_ID{peer.id}, b.Route.Members)
+	require.NotNil(t, snap.hist)
+
+	// Case: a snapshot whose revision is compacted before it's loaded fails.
+	snap, err = broker.svc.resolver.snapshot()
+	require.NoError(t, err)
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+
+	_, err = etcd.Compact(ctx, broker.ks.Header.Revision)
+	require.NoError(t, err)
+	_, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", snapshot: snap})
+	require.Regexp(t, "loading KeySpace at revision .*: .*compacted", err)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveWithBrokerSpec(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 1}, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "local/journal", Replication: 1}, broker.id)
+
+	var memberSpec = func(id pb.ProcessSpec_ID) *pb.BrokerSpec {
+		broker.ks.Mu.RLock()
+		defer broker.ks.Mu.RUnlock()
+
+		var member, ok = allocator.LookupMember(broker.ks, id.Zone, id.Suffix)
+		require.True(t, ok)
+		return member.MemberValue.(*pb.BrokerSpec)
+	}
+	var args = resolveArgs{
+		ctx:            ctx,
+		journal:        "peer/journal",
+		mayProxy:       true,
+		requirePrimary: true,
+		withBrokerSpec: true,
+	}
+
+	// Case: the spec of the resolved peer is attached.
+	var r, err = broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.Equal(t, peer.id, r.ProcessId)
+	require.Equal(t, memberSpec(peer.id), r.brokerSpec)
+
+	// Case: the spec of the local broker is attached.
+	args.journal = "local/journal"
+	r, err = broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.Equal(t, broker.id, r.ProcessId)
+	require.Equal(t, memberSpec(broker.id), r.brokerSpec)
+
+	// Case: the request may be proxied to any member. No spec is attached.
+	args.journal, args.requirePrimary = "peer/journal", false
+	r, err = broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.Equal(t, pb.ProcessSpec_ID{}, r.ProcessId)
+	require.Nil(t, r.brokerSpec)
+
+	// Case: the resolution isn't OK. No spec is attached.
+	args.journal = "does/not/exist"
+	r, err = broker.svc.resolver.resolve(args)
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+	require.Nil(t, r.brokerSpec)
+
+	// Case: specs aren't attached by default.
+	r, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "local/journal"})
+	require.NoError(t, err)
+	require.Nil(t, r.brokerSpec)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveUntilOK(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	// The journal has no primary, as though an election is underway.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2},
+		pb.ProcessSpec_ID{}, broker.id, peer.id)
+
+	var args = resolveArgs{journal: "a/journal", requirePrimary: true, mayProxy: true}
+	var retryable = func(status pb.Status) bool {
+		return status == pb.Status_NO_JOURNAL_PRIMARY_BROKER
+	}
+
+	// Case: a non-retryable status is returned immediately.
+	var r, err = broker.svc.resolver.resolveUntilOK(ctx, resolveArgs{journal: "does/not/exist"}, retryable)
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+
+	// Case: the context is done before a primary is elected.
+	var timeoutCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err = broker.svc.resolver.resolveUntilOK(timeoutCtx, args, retryable)
+	require.Equal(t, context.DeadlineExceeded, err)
+	cancel()
+
+	// Case: the call blocks until a primary is elected.
+	var resCh = make(chan *resolution)
+	go func() {
+		var r, err = broker.svc.resolver.resolveUntilOK(ctx, args, retryable)
+		require.NoError(t, err)
+		resCh <- r
+	}()
+
+	// An unrelated update doesn't unblock the call.
+	setTestJournal(broker, pb.JournalSpec{Name: "other/journal", Replication: 1}, peer.id)
+	select {
+	case <-resCh:
+		t.Fatal("unexpected resolution")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The peer is elected primary.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2},
+		peer.id, broker.id)
+
+	r = <-resCh
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, peer.id, r.ProcessId)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolverDrainForShutdown(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+	var resolver = broker.svc.resolver
+
+	// Give our BrokerSpec a non-zero JournalLimit.
+	var memberKey = allocator.MemberKey(broker.ks, broker.id.Zone, broker.id.Suffix)
+	var resp, err = etcd.Put(ctx, memberKey, (&pb.BrokerSpec{
+		ProcessSpec:  pb.ProcessSpec{Id: broker.id, Endpoint: broker.srv.Endpoint()},
+		JournalLimit: 10,
+	}).MarshalString())
+	require.NoError(t, err)
+
+	require.NoError(t, broker.svc.resolver.syncToRevision(ctx, resp.Header.Revision))
+
+	// We're primary of one journal, and a replica of another.
+	setTestJournal(broker, pb.JournalSpec{Name: "primary/journal", Replication: 2}, broker.id, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "replica/journal", Replication: 2}, peer.id, broker.id)
+
+	var doneCh = make(chan error)
+	go func() { doneCh <- resolver.drainForShutdown(ctx) }()
+
+	// Expect our JournalLimit is zeroed.
+	broker.ks.Mu.RLock()
+	for {
+		var member, ok = allocator.LookupMember(broker.ks, broker.id.Zone, broker.id.Suffix)
+		require.True(t, ok)
+
+		if member.MemberValue.(*pb.BrokerSpec).JournalLimit == 0 {
+			break
+		}
+		require.NoError(t, broker.ks.WaitForRevision(ctx, broker.ks.Header.Revision+1))
+	}
+	broker.ks.Mu.RUnlock()
+
+	// We continue to serve while we remain primary.
+	select {
+	case <-doneCh:
+		t.Fatal("unexpected drain completion")
+	case <-time.After(10 * time.Millisecond):
+	}
+	var r = broker.resolve("primary/journal")
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.ProcessId)
+
+	// The peer is elected primary. The drain completes, and local replicas are stopped.
+	setTestJournal(broker, pb.JournalSpec{Name: "primary/journal", Replication: 2}, peer.id, broker.id)
+	require.NoError(t, <-doneCh)
+
+	var local, _ = resolver.localJournals()
+	require.Empty(t, local)
+	_, err = resolver.resolve(resolveArgs{ctx: ctx, journal: "replica/journal"})
+	require.Equal(t, errResolverStopped, err)
+
+	// Case: the context is done before handoff completes.
+	var broker2 = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker2"})
+	setTestJournal(broker2, pb.JournalSpec{Name: "other/journal", Replication: 1}, broker2.id)
+
+	var timeoutCtx, cancel = context.WithTimeout(ctx, 10*time.Millisecond)
+	require.Equal(t, context.DeadlineExceeded, broker2.svc.resolver.drainForShutdown(timeoutCtx))
+	cancel()
+	// Local replicas are still served.
+	require.Equal(t, pb.Status_OK, broker2.resolve("other/journal").status)
+
+	broker.cleanup()
+	broker2.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveWithCandidates(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	// Give the peer a greater capacity than our own.
+	var resp, err = etcd.Put(ctx, allocator.MemberKey(broker.ks, peer.id.Zone, peer.id.Suffix),
+		(&pb.BrokerSpec{
+			ProcessSpec:  pb.ProcessSpec{Id: peer.id, Endpoint: peer.Endpoint()},
+			JournalLimit: 200,
+		}).MarshalString())
+	require.NoError(t, err)
+
+	require.NoError(t, broker.svc.resolver.syncToRevision(ctx, resp.Header.Revision))
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id, peer.id)
+
+	// Case: candidates are attached, with their capacities.
+	var r, _ = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", withCandidates: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, []resolveCandidate{
+		{id: broker.id, journalLimit: 0, primary: true},
+		{id: peer.id, journalLimit: 200, primary: false},
+	}, r.candidates)
+
+	// Case: candidates aren't attached by default.
+	r, _ = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+	require.Nil(t, r.candidates)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolvePrimaryTerm(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var resolve = func() *resolution {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", mayProxy: true})
+		require.NoError(t, err)
+		return r
+	}
+
+	// Case: there's no primary, and no term.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, pb.ProcessSpec_ID{}, broker.id)
+	require.Equal(t, int64(0), resolve().primaryTerm)
+
+	// Case: we're elected primary. The term is the revision of election.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id, peer.id)
+	var term = resolve().primaryTerm
+	require.Equal(t, broker.ks.Header.Revision, term)
+
+	// Case: a replica changes, but the primary's term is unchanged.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id)
+	require.Equal(t, term, resolve().primaryTerm)
+
+	// Case: the primary is reassigned, and the term changes.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, peer.id, broker.id)
+	require.Equal(t, broker.ks.Header.Revision, resolve().primaryTerm)
+	require.Greater(t, resolve().primaryTerm, term)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveRouteGeneration(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var resolve = func() *resolution {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", mayProxy: true})
+		require.NoError(t, err)
+		return r
+	}
+
+	// Case: the journal doesn't exist, and has no generation.
+	require.Equal(t, int64(0), resolve().routeGeneration)
+
+	// Case: the journal is created. Its generation is the revision of its assignments.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id)
+	var gen = resolve().routeGeneration
+	require.Equal(t, broker.ks.Header.Revision, gen)
+	// Resolving again without change doesn't alter the generation.
+	require.Equal(t, gen, resolve().routeGeneration)
+
+	// Case: an unrelated journal changes. The generation is unchanged.
+	setTestJournal(broker, pb.JournalSpec{Name: "other/journal", Replication: 1}, peer.id)
+	require.Equal(t, gen, resolve().routeGeneration)
+
+	// Case: a peer is added to the Route. The generation advances.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id, peer.id)
+	var next = resolve().routeGeneration
+	require.Equal(t, broker.ks.Header.Revision, next)
+	require.Greater(t, next, gen)
+	gen = next
+
+	// Case: the peer is removed, leaving the remaining assignment unchanged.
+	// The generation still advances.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id)
+	next = resolve().routeGeneration
+	require.Equal(t, broker.ks.Header.Revision, next)
+	require.Greater(t, next, gen)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveCachedRouteGeneration(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var resolve = func(cached int64) *resolution {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{
+			ctx:                   ctx,
+			journal:               "a/journal",
+			mayProxy:              true,
+			cachedRouteGeneration: cached,
+		})
+		require.NoError(t, err)
+		return r
+	}
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id, peer.id)
+
+	// Case: no cached generation. The full Route is resolved.
+	var full = resolve(0)
+	require.False(t, full.routeUnchanged)
+	require.Len(t, full.Route.Endpoints, 2)
+
+	// Case: the cached generation is current. Endpoints are omitted.
+	var r = resolve(full.routeGeneration)
+	require.True(t, r.routeUnchanged)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, full.Route.Members, r.Route.Members)
+	require.Equal(t, full.Route.Primary, r.Route.Primary)
+	require.Nil(t, r.Route.Endpoints)
+
+	// Case: the Route changes. The full Route is resolved despite the cached generation.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, peer.id, broker.id)
+	r = resolve(full.routeGeneration)
+	require.False(t, r.routeUnchanged)
+	require.Equal(t, full.Route.Members, r.Route.Members)
+	require.NotEqual(t, full.Route.Primary, r.Route.Primary)
+	require.Len(t, r.Route.Endpoints, 2)
+
+	// Case: a single local replica at its current generation.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+	full = resolve(0)
+	require.Equal(t, []pb.Endpoint{broker.srv.Endpoint()}, full.Route.Endpoints)
+	r = resolve(full.routeGeneration)
+	require.True(t, r.routeUnchanged)
+	require.Nil(t, r.Route.Endpoints)
+	require.NotNil(t, r.replica)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveSuggestedCacheTTL(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var clock = newTestClock(time.Now())
+	broker.svc.resolver.setClock(clock)
+
+	var resolve = func() time.Duration {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", mayProxy: true})
+		require.NoError(t, err)
+		return r.suggestedCacheTTL
+	}
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id, peer.id)
+
+	// Case: the Route was just observed. The TTL is short.
+	require.Equal(t, minRouteCacheTTL, resolve())
+
+	// Case: the Route has been stable for a while.
+	clock.advance(2 * time.Minute)
+	require.Equal(t, time.Minute, resolve())
+
+	// Case: the Route has been stable for a long time. The TTL is bounded.
+	clock.advance(time.Hour)
+	require.Equal(t, maxRouteCacheTTL, resolve())
+
+	// Case: the Route changes. The TTL is short again.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, peer.id, broker.id)
+	require.Equal(t, minRouteCacheTTL, resolve())
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolverWatchAssignments(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 1}, peer.id)
+
+	var watchCtx, cancel = context.WithCancel(ctx)
+	var events = broker.svc.WatchAssignments(watchCtx)
+
+	// Expect an initial event for the existing local journal.
+	var ev = <-events
+	require.Equal(t, AssignmentAdded, ev.Type)
+	require.Equal(t, pb.Journal("a/journal"), ev.Journal)
+	require.Equal(t, []pb.ProcessSpec_ID{broker.id}, ev.Route.Members)
+	require.Equal(t, []pb.Endpoint{broker.srv.Endpoint()}, ev.Route.Endpoints)
+
+	// A new local journal is assigned. Peer journals are not reported.
+	setTestJournal(broker, pb.JournalSpec{Name: "new/peer/journal", Replication: 1}, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "new/local/journal", Replication: 1}, broker.id)
+
+	ev = <-events
+	require.Equal(t, AssignmentAdded, ev.Type)
+	require.Equal(t, pb.Journal("new/local/journal"), ev.Journal)
+	require.Equal(t, broker.ks.Header.Revision, ev.Revision)
+
+	// The new journal's Route changes.
+	setTestJournal(broker, pb.JournalSpec{Name: "new/local/journal", Replication: 2}, broker.id, peer.id)
+
+	ev = <-events
+	require.Equal(t, AssignmentRouteChanged, ev.Type)
+	require.Equal(t, pb.Journal("new/local/journal"), ev.Journal)
+	require.Equal(t, []pb.ProcessSpec_ID{broker.id, peer.id}, ev.Route.Members)
+
+	// The journal is re-assigned away from this broker.
+	setTestJournal(broker, pb.JournalSpec{Name: "new/local/journal", Replication: 1}, peer.id)
+
+	ev = <-events
+	require.Equal(t, AssignmentRemoved, ev.Type)
+	require.Equal(t, pb.Journal("new/local/journal"), ev.Journal)
+
+	// Cancelling the context closes the channel.
+	cancel()
+	for range events {
+	}
+
+	// A second watcher observes removal of all journals as local replicas
+	// are stopped, and then its channel is closed.
+	events = broker.svc.WatchAssignments(ctx)
+	require.Equal(t, AssignmentAdded, (<-events).Type)
+	broker.svc.resolver.stopServingLocalReplicas()
+
+	ev = <-events
+	require.Equal(t, AssignmentRemoved, ev.Type)
+	require.Equal(t, pb.Journal("a/journal"), ev.Journal)
+	var _, ok = <-events
+	require.False(t, ok)
+
+	// Watches started after stopping are immediately closed.
+	_, ok = <-broker.svc.WatchAssignments(ctx)
+	require.False(t, ok)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveLogger(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 1}, peer.id)
+
+	var logger, hook = logtest.NewNullLogger()
+	ctx = WithResolveLogger(ctx, logger.WithField("test", "resolve"))
+
+	// Case: debug logging isn't enabled. Nothing is logged.
+	var _, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", requirePrimary: true})
+	require.NoError(t, err)
+	require.Empty(t, hook.AllEntries())
+
+	// Case: debug logging is enabled, and the resolution is logged.
+	logger.SetLevel(log.DebugLevel)
+	_, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", requirePrimary: true})
+	require.NoError(t, err)
+
+	require.Len(t, hook.AllEntries(), 1)
+	require.Equal(t, log.DebugLevel, hook.LastEntry().Level)
+	require.Equal(t, "resolved journal", hook.LastEntry().Message)
+	require.Equal(t, log.Fields{
+		"test":      "resolve",
+		"journal":   pb.Journal("peer/journal"),
+		"processId": broker.id,
+		"status":    pb.Status_NOT_JOURNAL_PRIMARY_BROKER,
+		"proxied":   false,
+		"local":     false,
+		"revision":  broker.ks.Header.Revision,
+		"stale":     false,
+		"fromProxy": false,
+		"term":      broker.ks.Header.Revision, // Assignment was created at this revision.
+	}, hook.LastEntry().Data)
+
+	// Case: the resolution is proxied.
+	_, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", requirePrimary: true, mayProxy: true})
+	require.NoError(t, err)
+	require.Len(t, hook.AllEntries(), 2)
+	require.Equal(t, peer.id, hook.LastEntry().Data["processId"])
+	require.Equal(t, pb.Status_OK, hook.LastEntry().Data["status"])
+	require.Equal(t, true, hook.LastEntry().Data["proxied"])
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveMany(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "local/journal", Replication: 1}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 1}, peer.id)
+
+	// Case: journals are resolved together, each with its own status.
+	var out, err = broker.svc.resolver.resolveMany(ctx,
+		[]pb.Journal{"local/journal;meta", "peer/journal", "does/not/exist"}, resolveArgs{})
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+
+	require.Equal(t, pb.Status_OK, out[0].status)
+	require.Equal(t, broker.id, out[0].Header.ProcessId)
+	require.NotNil(t, out[0].replica)
+	require.Equal(t, pb.Status_NOT_JOURNAL_BROKER, out[1].status)
+	require.Nil(t, out[1].replica)
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, out[2].status)
+
+	// All resolutions share the same Etcd header.
+	for _, res := range out {
+		require.Equal(t, pbx.FromEtcdResponseHeader(broker.ks.Header), res.Header.Etcd)
+		require.Equal(t, broker.id, res.localID)
+	}
+
+	// Case: request a future revision, and race the creation of a journal
+	// which is resolved alongside others. All resolutions reflect the future
+	// revision at which the new journal exists.
+	var futureRevision = broker.ks.Header.Revision + 1
+	time.AfterFunc(time.Millisecond, func() {
+		setTestJournal(broker, pb.JournalSpec{Name: "new/journal", Replication: 1}, broker.id)
+	})
+	out, err = broker.svc.resolver.resolveMany(ctx,
+		[]pb.Journal{"local/journal", "new/journal"}, resolveArgs{minEtcdRevision: futureRevision})
+	require.NoError(t, err)
+
+	require.Equal(t, pb.Status_OK, out[0].status)
+	require.Equal(t, pb.Status_OK, out[1].status)
+	require.Equal(t, out[0].Header.Etcd, out[1].Header.Etcd)
+	require.True(t, out[0].Header.Etcd.Revision >= futureRevision)
+
+	// Case: a proxyHeader check fails the entire batch.
+	var hdr = pb.Header{
+		ProcessId: pb.ProcessSpec_ID{Zone: "other", Suffix: "id"},
+		Route:     pb.Route{Primary: -1},
+		Etcd:      pbx.FromEtcdResponseHeader(broker.ks.Header),
+	}
+	_, err = broker.svc.resolver.resolveMany(ctx,
+		[]pb.Journal{"local/journal"}, resolveArgs{proxyHeader: &hdr})
+	require.Regexp(t, `proxied request ProcessId doesn't match our own`, err)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveNotFoundCache(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var resolver = broker.svc.resolver
+
+	// Case: a journal which doesn't exist is cached as such.
+	var r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+	require.True(t, resolver.notFound.contains("a/journal", broker.ks.Header.Revision, time.Now()))
+
+	// A subsequent resolution at the same revision is served from the cache.
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+	require.Equal(t, broker.id, r.Header.ProcessId)
+	require.Equal(t, pb.Route{Primary: -1}, r.Header.Route)
+	require.Nil(t, r.journalSpec)
+
+	// Entries expire after their TTL.
+	require.False(t, resolver.notFound.contains("a/journal", broker.ks.Header.Revision,
+		time.Now().Add(notFoundCacheTTL)))
+
+	// Case: the journal is created. The cache doesn't mask it.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+	require.False(t, resolver.notFound.contains("a/journal", broker.ks.Header.Revision, time.Now()))
+
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.Header.ProcessId)
+	require.False(t, r.stale)
+
+	// Case: a journal having assignments, but no JournalSpec, isn't cached.
+	setTestJournal(broker, pb.JournalSpec{Name: "b/journal", Replication: 1}, broker.id)
+	var resp, err = etcd.Delete(ctx, allocator.ItemKey(broker.ks, "b/journal"))
+	require.NoError(t, err)
+
+	require.NoError(t, broker.svc.resolver.syncToRevision(ctx, resp.Header.Revision))
+
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "b/journal"})
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+	require.Len(t, r.Header.Route.Members, 1)
+	require.False(t, resolver.notFound.contains("b/journal", broker.ks.Header.Revision, time.Now()))
+
+	broker.cleanup()
+}
+
+func TestResolveProxyHeaderErrorCases(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+
+	var proxy = pb.Header{
+		ProcessId: pb.ProcessSpec_ID{Zone: "other", Suffix: "id"},
+		Route:     pb.Route{Primary: -1},
+		Etcd:      pbx.FromEtcdResponseHeader(broker.ks.Header),
+	}
+
+	// Case: proxy header references a broker other than this one.
+	var _, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", proxyHeader: &proxy})
+	require.Regexp(t, `proxied request ProcessId doesn't match our own \(zone.*`, err)
+	proxy.ProcessId = broker.id
+
+	// Case: proxy header references a ClusterId other than our own.
+	proxy.Etcd.ClusterId = 8675309
+	_, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal", proxyHeader: &proxy})
+	require.Regexp(t, `proxied request Etcd ClusterId doesn't match our own \(\d+.*`, err)
+
+	broker.cleanup()
+}
+
+func TestResolveRoutePolicy(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peerA = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker-A"})
+	var peerB = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker-B"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 2}, peerA.id, peerB.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "local/journal", Replication: 2}, peerA.id, broker.id)
+	broker.initialFragmentLoad()
+
+	var resolver = broker.svc.resolver
+
+	// Case: the default policy leaves ProcessId empty, as we may proxy to any member.
+	var r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, pb.ProcessSpec_ID{}, r.Header.ProcessId)
+
+	// Install a policy which always prefers |peerB|.
+	var calls int
+	var selectID = peerB.id
+	broker.svc.SetRoutePolicy(routePolicyFunc(func(local pb.ProcessSpec_ID, journal pb.Journal, rt pb.Route) (pb.ProcessSpec_ID, bool) {
+		calls++
+		require.Equal(t, broker.id, local)
+		require.Equal(t, pb.Journal("peer/journal"), journal)
+		require.Len(t, rt.Members, 2)
+		return selectID, true
+	}))
+
+	// Case: the policy pins the resolution to |peerB|.
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, peerB.id, r.Header.ProcessId)
+	require.Equal(t, 1, calls)
+
+	// Case: the primary is required. The policy isn't consulted.
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true, requirePrimary: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, peerA.id, r.Header.ProcessId)
+	require.Equal(t, 1, calls)
+
+	// Case: we're a Route member. The policy isn't consulted.
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "local/journal", mayProxy: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.Header.ProcessId)
+	require.Equal(t, 1, calls)
+
+	// Case: the policy selects a broker which isn't a Route member. It's ignored.
+	selectID = pb.ProcessSpec_ID{Zone: "other", Suffix: "broker"}
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, pb.ProcessSpec_ID{}, r.Header.ProcessId)
+	require.Equal(t, 2, calls)
+
+	// Case: the journal doesn't exist. The policy isn't consulted.
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "does/not/exist", mayProxy: true})
+	require.Equal(t, pb.Status_JOURNAL_NOT_FOUND, r.status)
+	require.Equal(t, 2, calls)
+
+	// Case: the default policy is restored.
+	broker.svc.SetRoutePolicy(nil)
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true})
+	require.Equal(t, pb.ProcessSpec_ID{}, r.Header.ProcessId)
+	require.Equal(t, 2, calls)
+
+	broker.cleanup()
+	peerA.Cleanup()
+	peerB.Cleanup()
+}
+
+func TestResolveRecoveringReplicas(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 2}, broker.id, peer.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "single/journal", Replication: 1}, broker.id)
+
+	var resolve = func(args resolveArgs) *resolution {
+		args.ctx = ctx
+		var r, err = broker.svc.resolver.resolve(args)
+		require.NoError(t, err)
+		require.Equal(t, pb.Status_OK, r.status)
+		return r
+	}
+
+	// Case: a read of a journal whose local replica is recovering is proxied.
+	var r = resolve(resolveArgs{journal: "a/journal", mayProxy: true})
+	require.Equal(t, peer.id, r.ProcessId)
+	require.False(t, r.recovering)
+	require.NotNil(t, r.replica) // Attached, though not resolved to.
+
+	// Case: the recovering local replica is allowed.
+	r = resolve(resolveArgs{journal: "a/journal", mayProxy: true, allowRecovering: true})
+	require.Equal(t, broker.id, r.ProcessId)
+	require.True(t, r.recovering)
+
+	// Case: we may not proxy, so the local replica is used.
+	r = resolve(resolveArgs{journal: "a/journal"})
+	require.Equal(t, broker.id, r.ProcessId)
+	require.True(t, r.recovering)
+
+	// Case: the request was proxied to us, and is served locally.
+	var hdr = r.Header
+	r = resolve(resolveArgs{journal: "a/journal", mayProxy: true, proxyHeader: &hdr})
+	require.Equal(t, broker.id, r.ProcessId)
+	require.True(t, r.recovering)
+
+	// Case: the primary is required.
+	r = resolve(resolveArgs{journal: "a/journal", mayProxy: true, requirePrimary: true})
+	require.Equal(t, broker.id, r.ProcessId)
+	require.True(t, r.recovering)
+
+	// Case: there's no other member to proxy to.
+	r = resolve(resolveArgs{journal: "single/journal", mayProxy: true})
+	require.Equal(t, broker.id, r.ProcessId)
+	require.True(t, r.recovering)
+
+	// Case: replicas have completed recovery.
+	broker.initialFragmentLoad()
+
+	r = resolve(resolveArgs{journal: "a/journal", mayProxy: true})
+	require.Equal(t, broker.id, r.ProcessId)
+	require.False(t, r.recovering)
+	r = resolve(resolveArgs{journal: "single/journal", mayProxy: true})
+	require.False(t, r.recovering)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveMissingEndpoint(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peerA = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker-A"})
+	var peerB = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker-B"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 2}, peerB.id, peerA.id)
+
+	// Remove |peerB|'s BrokerSpec, as if its lease expired, while leaving
+	// its assignment in place.
+	var resp, err = etcd.Delete(ctx, allocator.MemberKey(broker.ks, peerB.id.Zone, peerB.id.Suffix))
+	require.NoError(t, err)
+	require.NoError(t, broker.svc.resolver.syncToRevision(ctx, resp.Header.Revision))
+
+	var resolver = broker.svc.resolver
+	var route = pb.Route{
+		Members:   []pb.ProcessSpec_ID{peerA.id, peerB.id},
+		Primary:   1,
+		Endpoints: []pb.Endpoint{peerA.Endpoint(), ""},
+	}
+
+	// Case: the primary is required, but has no Endpoint to proxy to.
+	var r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true, requirePrimary: true})
+	require.Equal(t, pb.Status_NO_JOURNAL_PRIMARY_BROKER, r.status)
+	require.Equal(t, broker.id, r.Header.ProcessId)
+	require.Equal(t, route, r.Header.Route)
+
+	// Case: we may not proxy. The status is unchanged.
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", requirePrimary: true})
+	require.Equal(t, pb.Status_NOT_JOURNAL_PRIMARY_BROKER, r.status)
+
+	// Case: a RoutePolicy selects the member lacking an Endpoint. It's ignored.
+	var selectID = peerB.id
+	broker.svc.SetRoutePolicy(routePolicyFunc(func(pb.ProcessSpec_ID, pb.Journal, pb.Route) (pb.ProcessSpec_ID, bool) {
+		return selectID, true
+	}))
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, pb.ProcessSpec_ID{}, r.Header.ProcessId)
+
+	// Case: a RoutePolicy selects a member having an Endpoint.
+	selectID = peerA.id
+	r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "peer/journal", mayProxy: true})
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, peerA.id, r.Header.ProcessId)
+
+	broker.cleanup()
+	peerA.Cleanup()
+	peerB.Cleanup()
+}
+
+func TestResolveNearestPeerRoutePolicy(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peerA = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker-A"})
+	var peerB = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker-B"})
+
+	setTestJournal(broker, pb.JournalSpec{Name: "peer/journal", Replication: 2}, peerA.id, peerB.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "single/journal", Replication: 1}, peerA.id)
+
+	// Stub RTT measurements, which initially are empty.
+	var rtts = make(map[pb.ProcessSpec_ID]time.Duration)
+	broker.svc.SetRoutePolicy(NewNearestPeerRoutePolicy(func(id pb.ProcessSpec_ID) (time.Duration, bool) {
+		var rtt, ok = rtts[id]
+		return rtt, ok
+	}))
+	var resolve = func(journal pb.Journal) pb.ProcessSpec_ID {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{ctx: ctx, journal: journal, mayProxy: true})
+		require.NoError(t, err)
+		require.Equal(t, pb.Status_OK, r.status)
+		return r.Header.ProcessId
+	}
+
+	// Case: no RTT data. We may proxy to any member.
+	require.Equal(t, pb.ProcessSpec_ID{}, resolve("peer/journal"))
+
+	// Case: only |peerB| has been measured. It's selected.
+	rtts[peerB.id] = 50 * time.Millisecond
+	require.Equal(t, peerB.id, resolve("peer/journal"))
+
+	// Case: |peerA| is nearer.
+	rtts[peerA.id] = 5 * time.Millisecond
+	require.Equal(t, peerA.id, resolve("peer/journal"))
+
+	// Case: |peerB| is now nearer.
+	rtts[peerB.id] = time.Millisecond
+	require.Equal(t, peerB.id, resolve("peer/journal"))
+
+	// Case: there's just one member, and the policy doesn't pin it.
+	require.Equal(t, pb.ProcessSpec_ID{}, resolve("single/journal"))
+
+	broker.cleanup()
+	peerA.Cleanup()
+	peerB.Cleanup()
+}
+
+// routePolicyFunc adapts a function to the RoutePolicy interface.
+type routePolicyFunc func(pb.ProcessSpec_ID, pb.Journal, pb.Route) (pb.ProcessSpec_ID, bool)
+
+func (fn routePolicyFunc) SelectProxy(local pb.ProcessSpec_ID, journal pb.Journal, rt pb.Route) (pb.ProcessSpec_ID, bool) {
+	return fn(local, journal, rt)
+}
+
+func BenchmarkResolveSingleLocalReplica(b *testing.B) {
+	benchmarkResolve(b, func(bk *testBroker, _ mockBroker) []pb.ProcessSpec_ID {
+		return []pb.ProcessSpec_ID{bk.id}
+	})
+}
+
+func BenchmarkResolveReplicatedLocalPrimary(b *testing.B) {
+	benchmarkResolve(b, func(bk *testBroker, peer mockBroker) []pb.ProcessSpec_ID {
+		return []pb.ProcessSpec_ID{bk.id, peer.id}
+	})
+}
+
+func benchmarkResolve(b *testing.B, assign func(*testBroker, mockBroker) []pb.ProcessSpec_ID) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(b, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(b, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var ids = assign(broker, peer)
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: int32(len(ids))}, ids...)
+
+	var args = resolveArgs{ctx: ctx, journal: "a/journal", requirePrimary: true}
+	b.ResetTimer()
+
+	for i := 0; i != b.N; i++ {
+		if r, err := broker.svc.resolver.resolve(args); err != nil || r.status != pb.Status_OK {
+			b.Fatal(err, r.status)
+		}
+	}
+	b.StopTimer()
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolvePendingDeletion(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+
+	var spec = pb.JournalSpec{
+		Name:        "a/journal",
+		Replication: 1,
+		LabelSet:    pb.MustLabelSet(labels.PendingDeletion, "retired"),
+	}
+	setTestJournal(broker, spec, broker.id)
+
+	// Case: appends are refused.
+	var r, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:            ctx,
+		journal:        "a/journal",
+		mayProxy:       true,
+		requirePrimary: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_NOT_ALLOWED, r.status)
+	require.Equal(t, broker.id, r.ProcessId)
+	require.Nil(t, r.replica)
+
+	// Case: reads which allow pending deletion are served.
+	r, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:                  ctx,
+		journal:              "a/journal",
+		mayProxy:             true,
+		allowPendingDeletion: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.ProcessId)
+	require.NotNil(t, r.replica)
+
+	// Case: removing the label restores appends.
+	spec.LabelSet = pb.LabelSet{}
+	setTestJournal(broker, spec, broker.id)
+
+	r, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:            ctx,
+		journal:        "a/journal",
+		mayProxy:       true,
+		requirePrimary: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+
+	broker.cleanup()
+}
+
+func TestResolveMaintenance(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	var resolve = func(journal pb.Journal, requirePrimary bool) *resolution {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{
+			ctx:             ctx,
+			journal:         journal,
+			mayProxy:        true,
+			requirePrimary:  requirePrimary,
+			allowRecovering: true,
+		})
+		require.NoError(t, err)
+		return r
+	}
+	// Place both a local and a remote journal under maintenance.
+	var local = pb.JournalSpec{
+		Name:        "local/journal",
+		Replication: 1,
+		LabelSet:    pb.MustLabelSet(labels.Maintenance, "TICKET-123"),
+	}
+	var remote = local
+	remote.Name = "remote/journal"
+
+	setTestJournal(broker, local, broker.id)
+	setTestJournal(broker, remote, peer.id)
+
+	for _, journal := range []pb.Journal{local.Name, remote.Name} {
+		// Appends are refused with a retryable status.
+		var r = resolve(journal, true)
+		require.Equal(t, pb.Status_NO_JOURNAL_PRIMARY_BROKER, r.status)
+		require.Equal(t, broker.id, r.ProcessId)
+		// Reads proceed.
+		require.Equal(t, pb.Status_OK, resolve(journal, false).status)
+	}
+
+	// Maintenance ends once the label is removed.
+	local.LabelSet, remote.LabelSet = pb.LabelSet{}, pb.LabelSet{}
+	setTestJournal(broker, local, broker.id)
+	setTestJournal(broker, remote, peer.id)
+
+	var r = resolve(local.Name, true)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.ProcessId)
+	r = resolve(remote.Name, true)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, peer.id, r.ProcessId)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolveLocalOnly(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+
+	// We're a non-primary member of "replica/journal", and not a member of
+	// "remote/journal".
+	setTestJournal(broker, pb.JournalSpec{Name: "replica/journal", Replication: 2}, peer.id, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "remote/journal", Replication: 1}, peer.id)
+
+	var resolve = func(journal pb.Journal) (*resolution, error) {
+		return broker.svc.resolver.resolve(resolveArgs{
+			ctx:       ctx,
+			journal:   journal,
+			localOnly: true,
+		})
+	}
+
+	// Case: the local replica is resolved, though it's not primary.
+	var r, err = resolve("replica/journal")
+	require.NoError(t, err)
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Equal(t, broker.id, r.ProcessId)
+	require.Equal(t, peer.id, r.Route.Members[r.Route.Primary])
+	require.NotNil(t, r.replica)
+
+	// Case: journals without a local replica fail with a clear error.
+	_, err = resolve("remote/journal")
+	require.True(t, errors.Is(err, errNoLocalReplica))
+	require.Regexp(t, `^journal has no local replica: remote/journal @ revision \d+$`, err)
+
+	_, err = resolve("does/not/exist")
+	require.True(t, errors.Is(err, errNoLocalReplica))
+
+	// Case: localOnly may not be combined with proxying or the primary.
+	_, err = broker.svc.resolver.resolve(resolveArgs{
+		ctx:       ctx,
+		journal:   "replica/journal",
+		mayProxy:  true,
+		localOnly: true,
+	})
+	require.EqualError(t, err,
+		"localOnly resolution of replica/journal may not proxy, require the primary, or be historical")
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolutionPrimary(t *testing.T) {
+	var local = pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"}
+	var peer = pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"}
+
+	for _, tc := range []struct {
+		primary             int32
+		members             []pb.ProcessSpec_ID
+		hasPrimary, isLocal bool
+	}{
+		{0, []pb.ProcessSpec_ID{local, peer}, true, true},  // Primary is local.
+		{1, []pb.ProcessSpec_ID{local, peer}, true, false}, // Primary is remote.
+		{-1, []pb.ProcessSpec_ID{local, peer}, false, false},
+		{-1, nil, false, false},
+		{2, []pb.ProcessSpec_ID{local, peer}, false, false}, // Out of range.
+	} {
+		var r = resolution{localID: local}
+		r.Route = pb.Route{Members: tc.members, Primary: tc.primary}
+
+		require.Equal(t, tc.hasPrimary, r.HasPrimary())
+		require.Equal(t, tc.isLocal, r.IsPrimaryLocal())
+	}
+}
+
+func TestResolveReadPeers(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+	var missing = pb.ProcessSpec_ID{Zone: "missing", Suffix: "broker"} // No BrokerSpec.
+
+	setTestJournal(broker, pb.JournalSpec{Name: "no/primary/journal", Replication: 3},
+		pb.ProcessSpec_ID{}, broker.id, peer.id, missing)
+	setTestJournal(broker, pb.JournalSpec{
+		Name:        "maintenance/journal",
+		Replication: 2,
+		LabelSet:    pb.MustLabelSet(labels.Maintenance, "TICKET-123"),
+	}, peer.id, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "ok/journal", Replication: 2}, peer.id, broker.id)
+
+	var resolve = func(journal pb.Journal) *resolution {
+		var r, err = broker.svc.resolver.resolve(resolveArgs{
+			ctx:            ctx,
+			journal:        journal,
+			mayProxy:       true,
+			requirePrimary: true,
+		})
+		require.NoError(t, err)
+		return r
+	}
+
+	// Case: there's no primary, and our replica is recovering. Only |peer|
+	// may serve a read, as |missing| has no Endpoint.
+	var r = resolve("no/primary/journal")
+	require.Equal(t, pb.Status_NO_JOURNAL_PRIMARY_BROKER, r.status)
+	require.Equal(t, []pb.ProcessSpec_ID{peer.id}, r.readPeers)
+
+	// Case: our replica has recovered, and may also serve a read.
+	broker.initialFragmentLoad()
+	r = resolve("no/primary/journal")
+	require.Equal(t, []pb.ProcessSpec_ID{broker.id, peer.id}, r.readPeers)
+
+	// Case: the journal is under maintenance. Its primary is excluded.
+	r = resolve("maintenance/journal")
+	require.Equal(t, pb.Status_NO_JOURNAL_PRIMARY_BROKER, r.status)
+	require.Equal(t, []pb.ProcessSpec_ID{broker.id}, r.readPeers)
+
+	// Case: resolutions having other statuses have no hint.
+	r = resolve("ok/journal")
+	require.Equal(t, pb.Status_OK, r.status)
+	require.Nil(t, r.readPeers)
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolverInvalidate(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var peer = newMockBroker(t, etcd, pb.ProcessSpec_ID{Zone: "peer", Suffix: "broker"})
+	var resolver = broker.svc.resolver
+
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "b/journal", Replication: 1}, broker.id)
+	setTestJournal(broker, pb.JournalSpec{Name: "remote/journal", Replication: 1}, peer.id)
+
+	var resolve = func(journal pb.Journal) *resolution {
+		var r, err = resolver.resolve(resolveArgs{ctx: ctx, journal: journal})
+		require.NoError(t, err)
+		return r
+	}
+	var a, b = resolve("a/journal"), resolve("b/journal")
+
+	// Expect a resolve awaiting invalidation of "a/journal" is woken.
+	var wokeCh = make(chan *resolution)
+	go func() {
+		<-a.invalidateCh
+		var r, _ = resolver.resolve(resolveArgs{ctx: ctx, journal: "a/journal"})
+		wokeCh <- r
+	}()
+
+	// Journals without a local replica, and meta segments, are ignored.
+	resolver.invalidate("a/journal;meta", "remote/journal", "does/not/exist")
+
+	var a2 = <-wokeCh
+	require.Equal(t, pb.Status_OK, a2.status)
+	require.Equal(t, a.replica, a2.replica)
+	require.Equal(t, a.Header, a2.Header) // Nothing actually changed.
+
+	// The re-resolution has a fresh invalidateCh, and "b/journal" is unaffected.
+	for _, ch := range []<-chan struct{}{a2.invalidateCh, b.invalidateCh} {
+		select {
+		case <-ch:
+			t.Fatal("unexpected invalidation")
+		default:
+		}
+	}
+
+	// Invalidation after local replicas are stopped is a no-op.
+	resolver.stopServingLocalReplicas()
+	resolver.invalidate("a/journal", "b/journal")
+
+	broker.cleanup()
+	peer.Cleanup()
+}
+
+func TestResolverRevisionLag(t *testing.T) {
+	var ctx, etcd = context.Background(), etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var broker = newTestBroker(t, etcd, pb.ProcessSpec_ID{Zone: "local", Suffix: "broker"})
+	var resolver = broker.svc.resolver
+
+	// Case: the KeySpace has read through the latest revision.
+	setTestJournal(broker, pb.JournalSpec{Name: "a/journal", Replication: 1}, broker.id)
+
+	var lag, err = resolver.revisionLag(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), lag)
+	require.Equal(t, 0.0, testutil.ToFloat64(keySpaceRevisionLag))
+
+	// Case: the cluster revision advances with writes the KeySpace doesn't observe.
+	for i := 0; i != 3; i++ {
+		_, err = etcd.Put(ctx, "/not/the/keyspace", "value")
+		require.NoError(t, err)
+	}
+	lag, err = resolver.revisionLag(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), lag)
+	require.Equal(t, 3.0, testutil.ToFloat64(keySpaceRevisionLag))
+
+	// Case: the KeySpace reads through the latest revision once again.
+	resp, err := etcd.Get(ctx, "/not/the/keyspace")
+	require.NoError(t, err)
+	setTestJournal(broker, pb.JournalSpec{Name: "b/journal", Replication: 1}, broker.id)
+	require.Less(t, resp.Header.Revision, broker.ks.Header.Revision)
+
+	lag, err = resolver.revisionLag(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), lag)
+
+	// Without an Etcd client, the lag can't be determined.
+	resolver.etcd = nil
+	_, err = resolver.revisionLag(ctx)
+	require.EqualError(t, err, "resolver cannot read the Etcd revision without an Etcd client")
+	resolver.etcd = etcd
 
 	broker.cleanup()
 }
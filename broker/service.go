@@ -42,9 +42,29 @@ func NewService(state *allocator.State, jc pb.JournalClient, etcd *clientv3.Clie
 		go pulseDaemon(svc, rep)
 		return rep
 	})
+	svc.resolver.etcd = etcd
 	return svc
 }
 
+// SetRoutePolicy installs a RoutePolicy which selects among the peers to
+// which a request may be proxied, such as by zone affinity or peer latency.
+// By default, a proxied request may be dispatched to any Route member.
+// SetRoutePolicy is typically called before QueueTasks, but may be called at
+// any time. A nil |policy| restores the default.
+func (svc *Service) SetRoutePolicy(policy RoutePolicy) { svc.resolver.setRoutePolicy(policy) }
+
+// Ready returns true if the Service is ready to serve its assigned journals,
+// for use by readiness probes. It's false until the Service's KeySpace has
+// loaded and includes its own BrokerSpec, and false with an error once the
+// Service has begun to stop serving local replicas.
+func (svc *Service) Ready() (bool, error) { return svc.resolver.Ready() }
+
+// WatchAssignments returns a channel of changes to journals assigned to this
+// broker. See AssignmentEvent.
+func (svc *Service) WatchAssignments(ctx context.Context) <-chan AssignmentEvent {
+	return svc.resolver.WatchAssignments(ctx)
+}
+
 // QueueTasks of the Service to watch its KeySpace and serve local replicas.
 func (svc *Service) QueueTasks(tasks *task.Group, server *server.Server, finishFn func()) {
 	var watchCtx, watchCancel = context.WithCancel(context.Background())
@@ -99,12 +119,15 @@ func (svc *Service) QueueTasks(tasks *task.Group, server *server.Server, finishF
 // resolve items via the Service resolver.
 func (svc *Service) Route(ctx context.Context, item string) pb.Route {
 	var res, err = svc.resolver.resolve(resolveArgs{
-		ctx:      ctx,
-		journal:  pb.Journal(item),
-		mayProxy: true,
+		ctx:                  ctx,
+		journal:              pb.Journal(item),
+		mayProxy:             true,
+		allowPendingDeletion: true,
 	})
 	if err == errResolverStopped {
 		return pb.Route{Primary: -1} // We're shutting down.
+	} else if err == errNotReady {
+		return pb.Route{Primary: -1} // |ctx| was done before our KeySpace loaded.
 	} else if err != nil {
 		// Otherwise cannot err because we use neither minEtcdRevision nor proxyHeader.
 		panic(err)
@@ -2,6 +2,7 @@ package broker
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/stretchr/testify/require"
@@ -61,6 +62,7 @@ func newTestBroker(t require.TestingT, etcd *clientv3.Client, id pb.ProcessSpec_
 		resolver:         newResolver(state, newReplica),
 		stopProxyReadsCh: make(chan struct{}),
 	}
+	bk.svc.resolver.etcd = etcd
 	bk.ks.WatchApplyDelay = 0 // Speed test execution.
 
 	// Establish broker member key & do initial KeySpace Load.
@@ -103,9 +105,10 @@ func (bk *testBroker) cleanup() {
 // resolve returns the resolution of |journal| against the testBroker.
 func (bk *testBroker) resolve(journal pb.Journal) *resolution {
 	var res, err = bk.svc.resolver.resolve(resolveArgs{
-		ctx:      context.Background(),
-		journal:  journal,
-		mayProxy: true,
+		ctx:             context.Background(),
+		journal:         journal,
+		mayProxy:        true,
+		allowRecovering: true,
 	})
 	require.NoError(bk.t, err)
 	return res
@@ -219,3 +222,67 @@ func mustKeyValues(t require.TestingT, etcd clientv3.KV, kvs map[string]string)
 
 	return resp.Header.Revision
 }
+
+// testClock is a clock which advances only as directed by its test.
+type testClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*testTimer]struct{}
+}
+
+type testTimer struct {
+	at time.Time
+	f  func()
+}
+
+func newTestClock(now time.Time) *testClock {
+	return &testClock{now: now, timers: make(map[*testTimer]struct{})}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if d <= 0 {
+		go f()
+		return func() bool { return false }
+	}
+	var timer = &testTimer{at: c.now.Add(d), f: f}
+	c.timers[timer] = struct{}{}
+
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		var _, ok = c.timers[timer]
+		delete(c.timers, timer)
+		return ok
+	}
+}
+
+// advance the clock by |d|, invoking timers which have since elapsed.
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for timer := range c.timers {
+		if !timer.at.After(c.now) {
+			delete(c.timers, timer)
+			go timer.f()
+		}
+	}
+}
+
+// pending returns the number of timers which haven't elapsed or been stopped.
+func (c *testClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
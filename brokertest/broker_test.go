@@ -214,6 +214,35 @@ func TestGracefulStopTimeout(t *testing.T) {
 	require.NoError(t, bkA.Tasks.Wait())
 }
 
+func TestResolveInvariants(t *testing.T) {
+	var etcd = etcdtest.TestClient()
+	defer etcdtest.Cleanup()
+
+	var ctx = pb.WithDispatchDefault(context.Background())
+	var bkA = NewBroker(t, etcd, "zone", "broker-A")
+	var bkB = NewBroker(t, etcd, "zone", "broker-B")
+
+	CreateJournals(t, bkA,
+		Journal(pb.JournalSpec{Name: "foo/bar", Replication: 2}),
+		Journal(pb.JournalSpec{Name: "foo/baz", Replication: 1}),
+	)
+
+	// Invariants hold for both brokers, for journals which are replicated
+	// across each, assigned to only one, and which don't exist.
+	for _, bk := range []*Broker{bkA, bkB} {
+		AssertResolveInvariants(t, bk, "foo/bar", "foo/baz", "does/not/exist")
+	}
+
+	// Zero required replicas, allowing brokers to exit.
+	updateReplication(t, ctx, bkA.Client(), "foo/bar", 0)
+	updateReplication(t, ctx, bkA.Client(), "foo/baz", 0)
+	bkA.Signal()
+	bkB.Signal()
+
+	require.NoError(t, bkA.Tasks.Wait())
+	require.NoError(t, bkB.Tasks.Wait())
+}
+
 func updateReplication(t require.TestingT, ctx context.Context, bk pb.JournalClient, journal pb.Journal, r int32) {
 	var lResp, err = bk.List(ctx, &pb.ListRequest{
 		Selector: pb.LabelSelector{Include: pb.MustLabelSet("name", journal.String())},
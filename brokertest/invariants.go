@@ -0,0 +1,114 @@
+package brokertest
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/require"
+	"go.gazette.dev/core/broker/client"
+	pb "go.gazette.dev/core/broker/protocol"
+)
+
+// AssertResolveInvariants issues RPCs to the Broker for each of |journals|,
+// under every combination of whether the journal primary is required (an
+// Append RPC) and whether the Broker may proxy (DoNotProxy), and asserts that
+// invariants of the broker's journal resolution hold for each response:
+//
+//   - The response Header is well-formed: Route Endpoints are attached for
+//     every Route member, and the Route Primary index is within range.
+//   - A resolution failure (eg, JOURNAL_NOT_FOUND or NOT_JOURNAL_BROKER) is
+//     authored by the Broker, and its Header ProcessId is the Broker's own.
+//   - A successful response which may not be proxied is served by the
+//     Broker, which is a Route member (and its primary, if required).
+//   - A successful response which may be proxied is served by a Route member
+//     (or by the Route primary, if required).
+//
+// Any other response status fails the assertion.
+//
+// The journal primary is required only by the Append RPC, so
+// AssertResolveInvariants issues real Append RPCs to each of |journals|. Each
+// Append is empty, and doesn't modify journal content, but it does sequence
+// through the journal's replication pipeline. Callers build the cluster under
+// test themselves, using NewBroker and CreateJournals to establish JournalSpecs
+// and their broker assignments.
+//
+// Whether a resolution attaches a local replica isn't observable from an RPC
+// response, and isn't checked. The broker package tests cover replica
+// attachment directly. AssertResolveInvariants is intended for use by packages
+// which extend or re-implement broker routing, and would like to verify that
+// resolution behavior is preserved.
+func AssertResolveInvariants(t require.TestingT, bk *Broker, journals ...pb.Journal) {
+	var ctx = pb.WithDispatchDefault(context.Background())
+
+	for _, journal := range journals {
+		for _, mayProxy := range []bool{false, true} {
+			// Case: the journal primary is not required.
+			var resp, err = bk.Client().ListFragments(ctx, &pb.FragmentsRequest{
+				Journal:    journal,
+				DoNotProxy: !mayProxy,
+			})
+			require.NoError(t, err)
+			assertResolvedHeader(t, bk, resp.Status, resp.Header, false, mayProxy)
+
+			// Case: the journal primary is required.
+			var app = client.NewAppender(ctx, bk.Client(), pb.AppendRequest{
+				Journal:    journal,
+				DoNotProxy: !mayProxy,
+			})
+			if err = app.Close(); app.Response.Status == pb.Status_OK {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, app.Response.Status.String())
+			}
+			assertResolvedHeader(t, bk, app.Response.Status, app.Response.Header, true, mayProxy)
+		}
+	}
+}
+
+func assertResolvedHeader(t require.TestingT, bk *Broker, status pb.Status, hdr pb.Header, requirePrimary, mayProxy bool) {
+	require.NoError(t, hdr.Validate())
+	require.Len(t, hdr.Route.Endpoints, len(hdr.Route.Members))
+
+	for _, ep := range hdr.Route.Endpoints {
+		require.NotEmpty(t, ep)
+	}
+
+	switch status {
+	case pb.Status_OK:
+		// Determine the Route index of the broker which served the RPC.
+		var ind = -1
+		for i, id := range hdr.Route.Members {
+			if id == hdr.ProcessId {
+				ind = i
+			}
+		}
+		require.NotEqual(t, -1, ind, "OK response served by a non-member of the Route")
+
+		if requirePrimary {
+			require.Equal(t, int32(ind), hdr.Route.Primary, "OK response not served by Route primary")
+		}
+		if !mayProxy {
+			require.Equal(t, bk.ID, hdr.ProcessId, "OK response was proxied, but DoNotProxy was set")
+		}
+
+	case pb.Status_JOURNAL_NOT_FOUND,
+		pb.Status_NO_JOURNAL_PRIMARY_BROKER,
+		pb.Status_NOT_JOURNAL_PRIMARY_BROKER,
+		pb.Status_NOT_JOURNAL_BROKER,
+		pb.Status_INSUFFICIENT_JOURNAL_BROKERS:
+
+		// We authored the resolution failure.
+		require.Equal(t, bk.ID, hdr.ProcessId, "resolution failure not authored by the Broker")
+
+		if status == pb.Status_NOT_JOURNAL_PRIMARY_BROKER || status == pb.Status_NOT_JOURNAL_BROKER {
+			require.False(t, mayProxy, "%s returned, but Broker may proxy", status)
+		}
+		if status == pb.Status_NO_JOURNAL_PRIMARY_BROKER {
+			require.True(t, requirePrimary, "%s returned, but primary was not required", status)
+			require.Equal(t, int32(-1), hdr.Route.Primary)
+		}
+
+	default:
+		require.Failf(t, "unexpected response status", "%s (requirePrimary: %t, mayProxy: %t)",
+			status, requirePrimary, mayProxy)
+	}
+}
@@ -53,15 +53,28 @@ const (
 	// AWS, Azure, or GCP regions like "us-central1", "us-east-1", etc. Only one
 	// Region label is allowed. Compare to failure-domain.beta.kubernetes.io/region.
 	Region = "app.gazette.dev/region"
+	// PendingDeletion marks a journal which is to be deleted. Brokers refuse
+	// appends to the journal, so that writers stop before its removal, but
+	// continue to serve reads. The label value is informational (eg, a reason
+	// or scheduled date). Only one PendingDeletion label is allowed.
+	PendingDeletion = "app.gazette.dev/pending-deletion"
+	// Maintenance marks a journal whose writes are quiesced for maintenance.
+	// Brokers refuse appends to the journal with a retryable status, as if it
+	// had no primary, and continue to serve reads. Writes resume once the
+	// label is removed. The label value is informational (eg, a ticket).
+	// Only one Maintenance label is allowed.
+	Maintenance = "app.gazette.dev/maintenance"
 )
 
 // SingleValueLabels identifies label names which must only have one label value
 // within a specification.
 var SingleValueLabels = map[string]struct{}{
-	ContentType:    {},
-	Instance:       {},
-	ManagedBy:      {},
-	MessageSubType: {},
-	MessageType:    {},
-	Region:         {},
+	ContentType:     {},
+	Instance:        {},
+	ManagedBy:       {},
+	Maintenance:     {},
+	MessageSubType:  {},
+	MessageType:     {},
+	PendingDeletion: {},
+	Region:          {},
 }